@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// command is one thing the binary does, picked by its first argument.
+// flags holds the command's own flags; every flag of the binary is also
+// accepted after the command's name.  args describes the arguments it
+// takes, nargs of them.
+type command struct {
+	name    string
+	aliases []string
+	args    string
+	nargs   int
+	summary string
+	help    string
+	flags   *flag.FlagSet
+	run     func(sources settingSources, args []string) error
+}
+
+var (
+	listFlags  = flag.NewFlagSet("list", flag.ContinueOnError)
+	listFormat = listFlags.String(
+		"format",
+		formatTable,
+		"How to print the backups: table, json or csv",
+	)
+
+	restoreFlags = flag.NewFlagSet("restore", flag.ContinueOnError)
+	restoreName  = restoreFlags.String(
+		"name",
+		"",
+		"Name tag of the new instance.  Defaults to the image's name.",
+	)
+	restoreInstanceType = restoreFlags.String(
+		"instance-type",
+		"",
+		"Instance type to launch.  Defaults to the source instance's.",
+	)
+	restoreSubnetID = restoreFlags.String(
+		"subnet-id",
+		"",
+		"Subnet to launch into.  Defaults to the source instance's.",
+	)
+	restoreKeyName = restoreFlags.String(
+		"key-name",
+		"",
+		"Key pair to launch with.  Defaults to the source instance's.",
+	)
+	restoreSecurityGroupIDs = restoreFlags.String(
+		"security-group-ids",
+		"",
+		"Comma separated security groups to launch with.  Defaults to the source instance's.",
+	)
+)
+
+var commands = []command{
+	{
+		name:    "create",
+		summary: "Back up every target, then prune its old backups",
+		help: `Backs up every target of the selected jobs, then deletes the backups
+their retention policies no longer keep.  With -dry-run it prints what
+it would create, keep and delete instead.  Running the binary without a
+command runs create.`,
+		flags: flag.NewFlagSet("create", flag.ContinueOnError),
+		run:   runCreate,
+	},
+	{
+		name:    "prune",
+		summary: "Delete the old backups of every target without backing it up",
+		help: `Deletes the backups of every target of the selected jobs that their
+retention policies no longer keep, without taking a new one.  With
+-dry-run it prints what it would keep and delete instead.`,
+		flags: flag.NewFlagSet("prune", flag.ContinueOnError),
+		run:   runPrune,
+	},
+	{
+		name:    "sweep",
+		summary: "Delete the snapshots of images that no longer exist",
+		help: `Deletes the snapshots whose description references an image that no
+longer exists and that are older than -sweep-grace, in every region the
+selected jobs back up or copy to and in their vaults.  Without jobs it
+sweeps aws-region.  With -dry-run it prints them instead.`,
+		flags: flag.NewFlagSet("sweep", flag.ContinueOnError),
+		run:   runSweep,
+	},
+	{
+		name:    "list",
+		summary: "List the backups of every target and whether they are kept",
+		help: `Lists the existing backups of every target of the selected jobs, as
+found by the same policy tag prune goes by: their ID, name, creation
+time, age, size, state and snapshots, and whether the next prune keeps
+them, and why.`,
+		flags: listFlags,
+		run:   runList,
+	},
+	{
+		name:    "show",
+		aliases: []string{"describe"},
+		args:    "<ami>",
+		nargs:   1,
+		summary: "Show one backup image in aws-region",
+		help: `Shows the backup image <ami> in aws-region: its name, state, snapshots
+and tags.`,
+		flags: flag.NewFlagSet("show", flag.ContinueOnError),
+		run:   runShow,
+	},
+	{
+		name:    "restore",
+		args:    "<ami>",
+		nargs:   1,
+		summary: "Launch an instance from a backup image in aws-region",
+		help: `Launches one instance from the backup image <ami> in aws-region.  What
+is not given by the flags below is copied from the instance the image
+is a backup of, if it still exists.  With -dry-run it prints the
+instance it would launch instead.`,
+		flags: restoreFlags,
+		run:   runRestore,
+	},
+	{
+		name:    "validate",
+		summary: "Check the config and list every problem in it",
+		help: `Checks the config without calling AWS and lists every problem in it,
+with its line.  Exits 1 if there are any.`,
+		flags: flag.NewFlagSet("validate", flag.ContinueOnError),
+		run:   runValidate,
+	},
+	{
+		name:    "config",
+		args:    "show",
+		nargs:   1,
+		summary: "Show every setting, where it came from, and the jobs",
+		help: `Shows the value of every setting and whether it came from a flag, the
+environment, the config or its default, then the jobs they add up to.`,
+		flags: flag.NewFlagSet("config", flag.ContinueOnError),
+		run:   runConfig,
+	},
+}
+
+// usage replaces flag.Usage before main parses the flags.
+func init() {
+	flag.Usage = usage
+}
+
+// findCommand returns the command called name, or nil.
+func findCommand(name string) *command {
+	for i, cmd := range commands {
+		if cmd.name == name {
+			return &commands[i]
+		}
+		for _, alias := range cmd.aliases {
+			if alias == name {
+				return &commands[i]
+			}
+		}
+	}
+	return nil
+}
+
+// parseCommand picks the command args start with, create when they are
+// empty, and parses the flags after it.  It returns the command and its
+// arguments.  Like flag.Parse it reports bad input itself, on stderr.
+func parseCommand(args []string) (*command, []string, error) {
+	var name = "create"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		return nil, nil, fmt.Errorf("Unknown command %q", name)
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	for _, set := range []*flag.FlagSet{cmd.flags, flag.CommandLine} {
+		set.VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		})
+	}
+	fs.Usage = func() { cmd.usage(os.Stderr) }
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = true
+	})
+	if fs.NArg() != cmd.nargs {
+		fmt.Fprintf(os.Stderr, "Wrong number of arguments for %s\n\n", cmd.name)
+		cmd.usage(os.Stderr)
+		return nil, nil, fmt.Errorf("Wrong number of arguments for %s", cmd.name)
+	}
+	return cmd, fs.Args(), nil
+}
+
+// usage writes the help for the whole binary, which flag.Usage is set to.
+func usage() {
+	var w = os.Stderr
+	fmt.Fprintf(
+		w,
+		"Usage: %s [flags] [command] [command flags] [arguments]\n\nCommands:\n",
+		os.Args[0],
+	)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(
+		w,
+		"\nWithout a command, %[1]s runs create.  Run '%[1]s <command> -h' for\nthe flags of one command.\n\nFlags, accepted before or after the command:\n",
+		os.Args[0],
+	)
+	flag.PrintDefaults()
+}
+
+// usage writes the help for cmd.
+func (cmd *command) usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s %s [flags]", os.Args[0], cmd.name)
+	if cmd.args != "" {
+		fmt.Fprintf(w, " %s", cmd.args)
+	}
+	fmt.Fprintf(w, "\n\n%s\n", cmd.help)
+	var own bool
+	cmd.flags.VisitAll(func(*flag.Flag) { own = true })
+	if own {
+		fmt.Fprintln(w, "\nFlags:")
+		cmd.flags.SetOutput(w)
+		cmd.flags.PrintDefaults()
+	}
+	fmt.Fprintf(w, "\nRun '%s -h' for the flags every command takes.\n", os.Args[0])
+}
+
+// setup reads the config and applies its settings, after which every
+// setting has its final value and logging can start.
+func setup(sources settingSources) (config, error) {
+	c, err := readConfig()
+	if err != nil {
+		return c, err
+	}
+	if err := sources.applyConfig(c.Settings); err != nil {
+		return c, err
+	}
+	if *logLocation != "" {
+		f, err := os.OpenFile(*logLocation, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return c, err
+		}
+		log.SetOutput(f)
+	}
+	return c, nil
+}
+
+// start is setup for the commands that call AWS.  It also checks the
+// settings those calls use and returns the context for the run.
+func start(sources settingSources) (context.Context, context.CancelFunc, config, error) {
+	c, err := setup(sources)
+	if err != nil {
+		return nil, nil, c, err
+	}
+	if err := checkRunSettings(); err != nil {
+		return nil, nil, c, err
+	}
+	ctx, cancel := runContext()
+	return ctx, cancel, c, nil
+}
+
+// checkRunSettings checks the timeouts and retry policy the AWS calls of a
+// run go by.
+func checkRunSettings() error {
+	if *timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if *waitTimeout <= 0 {
+		return fmt.Errorf("wait-timeout must be positive")
+	}
+	return retryPolicy().Validate()
+}
+
+// checkedJobs returns the jobs this run works on, having caught bad copies
+// and vaults in every region before any job has run.
+func checkedJobs(c config, sess *session.Session) ([]jobConfig, error) {
+	jobs, err := selectJobs(c, *job)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		for _, region := range j.regions() {
+			if _, err := j.options(sess, region); err != nil {
+				return nil, fmt.Errorf("Job %q in %s: %s", j.Name, region, err.Error())
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// runJobs calls fn for every target of jobs, as eachTarget does, and
+// fails if fn failed for any of them.  what names the work fn does.
+func runJobs(
+	ctx context.Context,
+	sess *session.Session,
+	jobs []jobConfig,
+	what string,
+	fn func(svc *snapshot.Client, t target) error,
+) error {
+	var total, failed int
+	for _, j := range jobs {
+		n, f := eachTarget(ctx, sess, j, fn)
+		total += n
+		failed += f
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d %s failed", failed, total, what)
+	}
+	return nil
+}
+
+func runCreate(sources settingSources, args []string) error {
+	ctx, cancel, c, err := start(sources)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	sess := session.New()
+	jobs, err := checkedJobs(c, sess)
+	if err != nil {
+		return err
+	}
+	var plan []snapshot.PlanItem
+	err = runJobs(ctx, sess, jobs, "backups", func(svc *snapshot.Client, t target) error {
+		if *dryRun {
+			items, err := svc.Plan(ctx, t.id)
+			plan = append(plan, items...)
+			return err
+		}
+		log.Print("Creating backup ", t.name, " of ", t.id, " in ", t.region)
+		resp, err := svc.Create(ctx, t.id)
+		if err != nil {
+			if resp != "" {
+				log.Print("Created ", resp, " with errors")
+			}
+			return err
+		}
+		log.Print("Success with message: ", resp)
+		return nil
+	})
+	if *dryRun {
+		if err := snapshot.WritePlan(os.Stdout, plan); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func runPrune(sources settingSources, args []string) error {
+	ctx, cancel, c, err := start(sources)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	sess := session.New()
+	jobs, err := checkedJobs(c, sess)
+	if err != nil {
+		return err
+	}
+	var plan []snapshot.PlanItem
+	err = runJobs(ctx, sess, jobs, "prunes", func(svc *snapshot.Client, t target) error {
+		if *dryRun {
+			backups, err := svc.List(ctx)
+			for _, b := range backups {
+				plan = append(plan, prunePlanItem(b))
+			}
+			return err
+		}
+		log.Print("Pruning backups ", t.name, " of ", t.id, " in ", t.region)
+		return svc.Prune(ctx)
+	})
+	if *dryRun {
+		if err := snapshot.WritePlan(os.Stdout, plan); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// runSweep sweeps everywhere the selected jobs keep backups: each region
+// they back up in, with their filters, the regions they copy to and their
+// vaults.  A place shared by several jobs is swept once.
+func runSweep(sources settingSources, args []string) error {
+	ctx, cancel, c, err := start(sources)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	sess := session.New()
+	jobs, err := sweepJobs(c)
+	if err != nil {
+		return err
+	}
+	var (
+		plan []snapshot.PlanItem
+		errs snapshot.MultiError
+		seen = map[string]bool{}
+	)
+	for _, j := range jobs {
+		for _, region := range j.regions() {
+			opts, err := j.options(sess, region)
+			if err != nil {
+				return fmt.Errorf("Job %q in %s: %s", j.Name, region, err.Error())
+			}
+			targets := append(
+				[]snapshot.CopyTarget{{Client: newEC2(sess, region), Region: region}},
+				opts.Copies...,
+			)
+			for _, t := range targets {
+				var filters = opts.Filters
+				if t.Account != "" {
+					filters = t.Filters
+				}
+				key := fmt.Sprint(t.Region, t.Account, filters)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				items, err := snapshot.SweepOrphans(
+					ctx,
+					snapshot.WithRetry(t.Client, retryPolicy()),
+					filters,
+					int64(*sweepGrace/time.Second),
+					*dryRun,
+				)
+				for _, item := range items {
+					item.Region, item.Account = t.Region, t.Account
+					plan = append(plan, item)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s", t.Region, err.Error()))
+				}
+			}
+		}
+	}
+	if *dryRun {
+		if err := snapshot.WritePlan(os.Stdout, plan); err != nil {
+			return err
+		}
+	} else {
+		for _, item := range plan {
+			log.Print("Swept snapshot ", item.ID, " in ", item.Region, " which ", item.Reason)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// sweepJobs returns the jobs whose backups runSweep covers.
+// A sweep needs no target, so without jobs or target flags the flags and
+// the top level of the config describe it on their own.
+func sweepJobs(c config) ([]jobConfig, error) {
+	if len(c.Jobs) == 0 && !targetFlags() {
+		return []jobConfig{flagJob(c)}, nil
+	}
+	return selectJobs(c, *job)
+}
+
+// prunePlanItem returns what prune does with b.
+func prunePlanItem(b snapshot.Backup) snapshot.PlanItem {
+	var item = snapshot.PlanItem{
+		Action:   snapshot.ActionDelete,
+		Resource: snapshot.ResourceImage,
+		ID:       b.ImageID,
+		Name:     b.Name,
+		Region:   b.Region,
+		Account:  b.Account,
+		Reason:   b.Reason,
+	}
+	if b.Keep {
+		item.Action = snapshot.ActionKeep
+	}
+	if b.ImageID == "" {
+		item.Resource = snapshot.ResourceSnapshotSet
+	}
+	return item
+}
+
+func runList(sources settingSources, args []string) error {
+	if err := checkFormat(*listFormat); err != nil {
+		return err
+	}
+	ctx, cancel, c, err := start(sources)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	sess := session.New()
+	jobs, err := checkedJobs(c, sess)
+	if err != nil {
+		return err
+	}
+	var (
+		items []inventoryItem
+		now   = time.Now()
+	)
+	err = runJobs(ctx, sess, jobs, "listings", func(svc *snapshot.Client, t target) error {
+		backups, err := svc.List(ctx)
+		for _, b := range backups {
+			items = append(items, newInventoryItem(t, b, now))
+		}
+		return err
+	})
+	if err := writeInventory(os.Stdout, *listFormat, items); err != nil {
+		return err
+	}
+	return err
+}
+
+func runShow(sources settingSources, args []string) error {
+	ctx, cancel, _, err := start(sources)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	image, err := snapshot.FindImage(ctx, regionClient(session.New(), *awsRegion), args[0])
+	if err != nil {
+		return err
+	}
+	return writeImage(os.Stdout, image)
+}
+
+// writeImage writes what show prints about image.
+func writeImage(w io.Writer, image *ec2.Image) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", aws.StringValue(image.ImageId))
+	fmt.Fprintf(tw, "Name\t%s\n", aws.StringValue(image.Name))
+	fmt.Fprintf(tw, "State\t%s\n", aws.StringValue(image.State))
+	fmt.Fprintf(tw, "Created\t%s\n", aws.StringValue(image.CreationDate))
+	if image.Description != nil {
+		fmt.Fprintf(tw, "Description\t%s\n", *image.Description)
+	}
+	var label = "Snapshots"
+	for _, device := range image.BlockDeviceMappings {
+		if device.Ebs == nil {
+			continue
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s %s %d GiB\n",
+			label,
+			aws.StringValue(device.DeviceName),
+			aws.StringValue(device.Ebs.SnapshotId),
+			aws.Int64Value(device.Ebs.VolumeSize),
+		)
+		label = ""
+	}
+	var tags = map[string]string{}
+	for _, tag := range image.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	label = "Tags"
+	for _, key := range sortedKeys(tags) {
+		fmt.Fprintf(tw, "%s\t%s=%s\n", label, key, tags[key])
+		label = ""
+	}
+	return tw.Flush()
+}
+
+func runRestore(sources settingSources, args []string) error {
+	ctx, cancel, _, err := start(sources)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	client := regionClient(session.New(), *awsRegion)
+	input, err := snapshot.RestoreInput(ctx, client, snapshot.RestoreOptions{
+		ImageID:          args[0],
+		Name:             *restoreName,
+		InstanceType:     *restoreInstanceType,
+		SubnetID:         *restoreSubnetID,
+		KeyName:          *restoreKeyName,
+		SecurityGroupIDs: splitList(*restoreSecurityGroupIDs),
+	})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return snapshot.WritePlan(os.Stdout, []snapshot.PlanItem{restorePlanItem(input)})
+	}
+	id, err := snapshot.Restore(ctx, client, input)
+	if err != nil {
+		return err
+	}
+	log.Print("Launched ", id, " from ", args[0])
+	fmt.Println(id)
+	return nil
+}
+
+// restorePlanItem returns what restore does with input.
+func restorePlanItem(input *ec2.RunInstancesInput) snapshot.PlanItem {
+	var item = snapshot.PlanItem{
+		Action:   snapshot.ActionCreate,
+		Resource: snapshot.ResourceInstance,
+		Region:   *awsRegion,
+	}
+	for _, spec := range input.TagSpecifications {
+		for _, tag := range spec.Tags {
+			if aws.StringValue(tag.Key) == "Name" {
+				item.Name = aws.StringValue(tag.Value)
+			}
+		}
+	}
+	var details = []string{
+		"from " + aws.StringValue(input.ImageId),
+		"as " + aws.StringValue(input.InstanceType),
+	}
+	if input.SubnetId != nil {
+		details = append(details, "in "+*input.SubnetId)
+	}
+	if input.KeyName != nil {
+		details = append(details, "with key "+*input.KeyName)
+	}
+	if len(input.SecurityGroupIds) > 0 {
+		details = append(
+			details,
+			"in groups "+strings.Join(aws.StringValueSlice(input.SecurityGroupIds), ","),
+		)
+	}
+	item.Reason = strings.Join(details, " ")
+	return item
+}
+
+func runValidate(sources settingSources, args []string) error {
+	if n := validateConfig(os.Stdout, *configLocation); n > 0 {
+		return fmt.Errorf("%s has %d problem(s)", *configLocation, n)
+	}
+	return nil
+}
+
+func runConfig(sources settingSources, args []string) error {
+	if args[0] != "show" {
+		return fmt.Errorf("Unknown command %q", "config "+args[0])
+	}
+	c, err := setup(sources)
+	if err != nil {
+		return err
+	}
+	// the settings are worth seeing even when they add up to no valid jobs,
+	// which are reported after them
+	jobs, jobsErr := selectJobs(c, *job)
+	if err := showConfig(os.Stdout, sources, jobs); err != nil {
+		return err
+	}
+	return jobsErr
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseCommand(t *testing.T) {
+	defer func(keep int, instanceType string) {
+		*keepLast, *restoreInstanceType = keep, instanceType
+		delete(commandLine, "keep-last")
+		delete(commandLine, "instance-type")
+	}(*keepLast, *restoreInstanceType)
+
+	var tests = []struct {
+		args   []string
+		expect string
+		rest   []string
+		fail   bool
+	}{
+		// the flag-only invocation still creates backups
+		{args: nil, expect: "create"},
+		{args: []string{"list"}, expect: "list", rest: []string{}},
+		{args: []string{"describe", "ami-123456a"}, expect: "show", rest: []string{"ami-123456a"}},
+		{
+			args:   []string{"restore", "-instance-type", "t2.micro", "ami-123456a"},
+			expect: "restore",
+			rest:   []string{"ami-123456a"},
+		},
+		{args: []string{"prune", "-keep-last", "4"}, expect: "prune", rest: []string{}},
+		{args: []string{"sweep"}, expect: "sweep", rest: []string{}},
+		{args: []string{"config", "show"}, expect: "config", rest: []string{"show"}},
+		{args: []string{"show"}, fail: true},
+		{args: []string{"create", "web"}, fail: true},
+		{args: []string{"list", "-instance-type", "t2.micro"}, fail: true},
+		{args: []string{"backup"}, fail: true},
+		{args: []string{"prune", "-sweep-orphans"}, fail: true},
+	}
+	for _, test := range tests {
+		cmd, rest, err := parseCommand(test.args)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected an error for %v but got nil", test.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected nil for %v but got %v", test.args, err)
+			continue
+		}
+		if cmd.name != test.expect || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("Expected %s %v got %s %v", test.expect, test.rest, cmd.name, rest)
+		}
+	}
+	// flags after the command are command line settings like any other
+	if *restoreInstanceType != "t2.micro" || *keepLast != 4 || !commandLine["keep-last"] {
+		t.Errorf(
+			"Expected instance-type t2.micro and keep-last 4 from the command line got %s and %d",
+			*restoreInstanceType,
+			*keepLast,
+		)
+	}
+}
+
+func TestSweepJobs(t *testing.T) {
+	var web = jobConfig{Name: "web", InstanceTags: "Role=web", Regions: []string{"eu-west-1"}}
+
+	// without jobs or a target the top level alone is swept
+	jobs, err := sweepJobs(config{Copies: []copyConfig{{Region: "us-west-2"}}})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "default" || len(jobs[0].Copies) != 1 {
+		t.Errorf("Expected the top level as the only job got %+v", jobs)
+	}
+
+	jobs, err = sweepJobs(config{Jobs: []jobConfig{web}})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "web" || jobs[0].regions()[0] != "eu-west-1" {
+		t.Errorf("Expected the web job got %+v", jobs)
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	var out bytes.Buffer
+	err := writeImage(&out, &ec2.Image{
+		ImageId:      aws.String("ami-123456a"),
+		Name:         aws.String("web01.backup-20160315120000"),
+		State:        aws.String(ec2.ImageStateAvailable),
+		CreationDate: aws.String("2016-03-15T12:00:00.000Z"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs: &ec2.EbsBlockDevice{
+					SnapshotId: aws.String("snap-1"),
+					VolumeSize: aws.Int64(8),
+				},
+			},
+			{DeviceName: aws.String("/dev/sdb")},
+			{
+				DeviceName: aws.String("/dev/sdf"),
+				Ebs: &ec2.EbsBlockDevice{
+					SnapshotId: aws.String("snap-2"),
+					VolumeSize: aws.Int64(100),
+				},
+			},
+		},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("ec2_snapshot:policy"), Value: aws.String("web01.backup")},
+			{Key: aws.String("Name"), Value: aws.String("web01")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	var expect = `ID         ami-123456a
+Name       web01.backup-20160315120000
+State      available
+Created    2016-03-15T12:00:00.000Z
+Snapshots  /dev/xvda snap-1 8 GiB
+           /dev/sdf snap-2 100 GiB
+Tags       Name=web01
+           ec2_snapshot:policy=web01.backup
+`
+	if out.String() != expect {
+		t.Errorf("Expected\n%s\ngot\n%s", expect, out.String())
+	}
+}
+
+func TestPrunePlanItem(t *testing.T) {
+	var created = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		backup snapshot.Backup
+		expect snapshot.PlanItem
+	}{
+		{
+			backup: snapshot.Backup{
+				ImageID: "ami-123456a",
+				Name:    "web01.backup-20160315120000",
+				Region:  "us-east-1",
+				Created: created,
+				Keep:    true,
+				Reason:  "newest 3",
+			},
+			expect: snapshot.PlanItem{
+				Action:   snapshot.ActionKeep,
+				Resource: snapshot.ResourceImage,
+				ID:       "ami-123456a",
+				Name:     "web01.backup-20160315120000",
+				Region:   "us-east-1",
+				Reason:   "newest 3",
+			},
+		},
+		{
+			backup: snapshot.Backup{
+				Name:        "data01.20160315120000",
+				SnapshotIDs: []string{"snap-1"},
+				Region:      "us-east-1",
+				Created:     created,
+			},
+			expect: snapshot.PlanItem{
+				Action:   snapshot.ActionDelete,
+				Resource: snapshot.ResourceSnapshotSet,
+				Name:     "data01.20160315120000",
+				Region:   "us-east-1",
+			},
+		},
+	}
+	for _, test := range tests {
+		if result := prunePlanItem(test.backup); result != test.expect {
+			t.Errorf("Expected %+v got %+v", test.expect, result)
+		}
+	}
+}
+
+func TestRestorePlanItem(t *testing.T) {
+	var result = restorePlanItem(&ec2.RunInstancesInput{
+		ImageId:          aws.String("ami-123456a"),
+		InstanceType:     aws.String("m4.large"),
+		SubnetId:         aws.String("subnet-1"),
+		SecurityGroupIds: aws.StringSlice([]string{"sg-1", "sg-2"}),
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeInstance),
+				Tags: []*ec2.Tag{
+					{Key: aws.String("Name"), Value: aws.String("web01-restored")},
+				},
+			},
+		},
+	})
+	var expect = snapshot.PlanItem{
+		Action:   snapshot.ActionCreate,
+		Resource: snapshot.ResourceInstance,
+		Name:     "web01-restored",
+		Region:   "us-east-1",
+		Reason:   "from ami-123456a as m4.large in subnet-1 in groups sg-1,sg-2",
+	}
+	if result != expect {
+		t.Errorf("Expected %+v got %+v", expect, result)
+	}
+}
+
+func TestCheckRunSettings(t *testing.T) {
+	defer func(run, wait time.Duration, attempts int) {
+		*timeout, *waitTimeout, *retryAttempts = run, wait, attempts
+	}(*timeout, *waitTimeout, *retryAttempts)
+
+	var tests = []struct {
+		timeout     time.Duration
+		waitTimeout time.Duration
+		attempts    int
+		fail        bool
+	}{
+		{timeout: 0, waitTimeout: time.Hour, attempts: 8},
+		{timeout: time.Hour, waitTimeout: time.Minute, attempts: 1},
+		{timeout: -time.Second, waitTimeout: time.Hour, attempts: 8, fail: true},
+		{timeout: 0, waitTimeout: 0, attempts: 8, fail: true},
+		{timeout: 0, waitTimeout: time.Hour, attempts: -1, fail: true},
+	}
+	for _, test := range tests {
+		*timeout, *waitTimeout, *retryAttempts = test.timeout, test.waitTimeout, test.attempts
+		if err := checkRunSettings(); (err != nil) != test.fail {
+			t.Errorf("Expected failure %t for %+v got %v", test.fail, test, err)
+		}
+	}
+}
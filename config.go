@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var (
+	awsRegion = flag.String(
+		"aws-region",
+		"us-east-1",
+		"AWS region where instance lives",
+	)
+	instanceID = flag.String(
+		"instance-id",
+		"",
+		"Instance Id to be backed up instead of the jobs in the config",
+	)
+	imageName = flag.String(
+		"image-name",
+		"",
+		"Name of backed up image.  Required with instance-id or volume-id, a prefix for discovered targets.",
+	)
+	timeToSave = durationFlag(
+		"time-to-save",
+		7*24*time.Hour,
+		"How long backups are saved, in seconds or as e.g. 36h, 7d or 2w",
+	)
+	keepLast = flag.Int(
+		"keep-last",
+		0,
+		"Number of newest backups to keep regardless of time-to-save",
+	)
+	configLocation = flag.String(
+		"config",
+		"./config.yml",
+		"Full or relative path to config location",
+	)
+	logLocation = flag.String(
+		"log-location",
+		"",
+		"File to append the log to.  Logs to stdout if not provided.",
+	)
+	dryRun = flag.Bool(
+		"dry-run",
+		false,
+		"Print the images and snapshots that would be created, kept and deleted without changing anything",
+	)
+	sweepGrace = durationFlag(
+		"sweep-grace",
+		24*time.Hour,
+		"How long an orphaned snapshot must have existed before sweep deletes it",
+	)
+	waitTimeout = durationFlag(
+		"wait-timeout",
+		snapshot.DefaultWaitTimeout,
+		"How long to wait for a new image to become available before giving up without pruning",
+	)
+	timeout = durationFlag(
+		"timeout",
+		0,
+		"How long the whole run may take before it stops cleanly.  0 means no limit.",
+	)
+	retryAttempts = flag.Int(
+		"retry-attempts",
+		snapshot.DefaultMaxAttempts,
+		"Times an EC2 call that is throttled or fails transiently is tried before giving up.  1 turns retrying off.",
+	)
+	retryBudget = durationFlag(
+		"retry-budget",
+		snapshot.DefaultRetryBudget,
+		"How long one EC2 call may spend being retried, including backoff",
+	)
+	mode = flag.String(
+		"mode",
+		snapshot.ModeImage,
+		"What to back up instances as: image for an AMI, snapshots for a crash-consistent set of EBS snapshots of their volumes",
+	)
+	excludeBootVolume = flag.Bool(
+		"exclude-boot-volume",
+		false,
+		"Leave the root volume out of snapshot sets.  Only used with mode snapshots.",
+	)
+	excludeDevices = flag.String(
+		"exclude-devices",
+		"",
+		"Comma separated device names, such as /dev/sdf, to leave out of snapshot sets.  Only used with mode snapshots.",
+	)
+	noReboot = flag.Bool(
+		"no-reboot",
+		false,
+		"Take images without shutting instances down first.  Use hooks to make the filesystems consistent instead.  Only used with mode image.",
+	)
+	job = flag.String(
+		"job",
+		"",
+		"Name of the one job in the config to run.  All jobs are run if not provided.",
+	)
+	instanceTags = flag.String(
+		"instance-tags",
+		"",
+		"Comma separated key=value tags used to discover instances to back up.  Ignored if instance-id is provided.",
+	)
+	volumeID = flag.String(
+		"volume-id",
+		"",
+		"Volume Id to be snapshotted instead of an instance",
+	)
+	volumeTags = flag.String(
+		"volume-tags",
+		"",
+		"Comma separated key=value tags used to discover volumes to snapshot instead of instances.  Ignored if volume-id is provided.",
+	)
+)
+
+type copyConfig struct {
+	Region   string `yaml:"region"`
+	KmsKeyID string `yaml:"kms_key_id,omitempty"`
+}
+
+type hookConfig struct {
+	Command string   `yaml:"command,omitempty"`
+	Timeout duration `yaml:"timeout,omitempty"`
+}
+
+type hooksConfig struct {
+	Pre  hookConfig `yaml:"pre,omitempty"`
+	Post hookConfig `yaml:"post,omitempty"`
+}
+
+type filterConfig struct {
+	Key    string   `yaml:"key"`
+	Values []string `yaml:"values"`
+}
+
+type config struct {
+	Filters         []filterConfig    `yaml:"filters"`
+	InstanceFilters []filterConfig    `yaml:"instance_filters"`
+	VolumeFilters   []filterConfig    `yaml:"volume_filters"`
+	Retention       snapshot.Schedule `yaml:"retention"`
+	Tags            map[string]string `yaml:"tags"`
+	Copies          []copyConfig      `yaml:"copies"`
+	ShareAccounts   []string          `yaml:"share_accounts"`
+	Vault           *vaultConfig      `yaml:"vault"`
+	Hooks           hooksConfig       `yaml:"hooks"`
+	Jobs            []jobConfig       `yaml:"jobs"`
+	Settings        map[string]string `yaml:"settings"`
+}
+
+// jobKeys returns the top level keys set in c that configure the job the
+// flags describe.  Jobs do not inherit them, so they are refused once the
+// config has jobs rather than silently applying to none of them.
+func (c config) jobKeys() []string {
+	var result []string
+	for _, key := range []struct {
+		name string
+		set  bool
+	}{
+		{"filters", len(c.Filters) > 0},
+		{"instance_filters", len(c.InstanceFilters) > 0},
+		{"volume_filters", len(c.VolumeFilters) > 0},
+		{"retention", c.Retention != snapshot.Schedule{}},
+		{"tags", len(c.Tags) > 0},
+		{"copies", len(c.Copies) > 0},
+		{"share_accounts", len(c.ShareAccounts) > 0},
+		{"vault", c.Vault != nil},
+		{"hooks", c.Hooks != hooksConfig{}},
+	} {
+		if key.set {
+			result = append(result, key.name)
+		}
+	}
+	return result
+}
+
+// readConfig loads the config strictly and fails on every problem in it,
+// so that a typo can never widen what a run touches.
+func readConfig() (config, error) {
+	c, found, err := loadConfig(*configLocation)
+	if err != nil || len(found) == 0 {
+		return c, err
+	}
+	var msgs = []string{}
+	for _, p := range found {
+		msgs = append(msgs, p.format(*configLocation))
+	}
+	return c, fmt.Errorf("Invalid config:\n%s", strings.Join(msgs, "\n"))
+}
+
+// getCopies returns the regions each backup taken in region is copied to.
+func getCopies(copies []copyConfig, region string) ([]copyConfig, error) {
+	for _, target := range copies {
+		if target.Region == "" || target.Region == region {
+			return nil, fmt.Errorf(
+				"Copy region %q must differ from %q",
+				target.Region,
+				region,
+			)
+		}
+	}
+	return copies, nil
+}
+
+// hooks returns the hooks from the config.
+func (h hooksConfig) hooks() snapshot.Hooks {
+	return snapshot.Hooks{
+		Pre: snapshot.Hook{
+			Command: h.Pre.Command,
+			Timeout: h.Pre.Timeout.Duration,
+		},
+		Post: snapshot.Hook{
+			Command: h.Post.Command,
+			Timeout: h.Post.Timeout.Duration,
+		},
+	}
+}
+
+func toEC2Filters(filters []filterConfig) []*ec2.Filter {
+	var result = []*ec2.Filter{}
+	for _, f := range filters {
+		var values = []*string{}
+		for _, val := range f.Values {
+			values = append(values, aws.String(val))
+		}
+		result = append(
+			result,
+			&ec2.Filter{
+				Name:   aws.String(f.Key),
+				Values: values,
+			},
+		)
+	}
+	return result
+}
@@ -0,0 +1,11 @@
+module github.com/PermissionData/ec2_snapshot
+
+go 1.19
+
+require (
+	github.com/aws/aws-sdk-go v1.55.8
+	github.com/golang/mock v1.6.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// jobConfig is one named backup in the config: what to back up, how to name
+// it and how long to keep it, in each of Regions.  An unset TimeToSave
+// falls back to the time-to-save flag and unset Regions to aws-region.
+type jobConfig struct {
+	Name              string            `yaml:"name"`
+	InstanceID        string            `yaml:"instance_id,omitempty"`
+	InstanceTags      string            `yaml:"instance_tags,omitempty"`
+	InstanceFilters   []filterConfig    `yaml:"instance_filters,omitempty"`
+	VolumeID          string            `yaml:"volume_id,omitempty"`
+	VolumeTags        string            `yaml:"volume_tags,omitempty"`
+	VolumeFilters     []filterConfig    `yaml:"volume_filters,omitempty"`
+	ImageName         string            `yaml:"image_name,omitempty"`
+	NameTemplate      string            `yaml:"name_template,omitempty"`
+	Regions           []string          `yaml:"regions,omitempty"`
+	TimeToSave        duration          `yaml:"time_to_save,omitempty"`
+	KeepLast          int               `yaml:"keep_last,omitempty"`
+	Retention         snapshot.Schedule `yaml:"retention,omitempty"`
+	Tags              map[string]string `yaml:"tags,omitempty"`
+	Filters           []filterConfig    `yaml:"filters,omitempty"`
+	Mode              string            `yaml:"mode,omitempty"`
+	NoReboot          bool              `yaml:"no_reboot,omitempty"`
+	ExcludeBootVolume bool              `yaml:"exclude_boot_volume,omitempty"`
+	ExcludeDevices    []string          `yaml:"exclude_devices,omitempty"`
+	Copies            []copyConfig      `yaml:"copies,omitempty"`
+	ShareAccounts     []string          `yaml:"share_accounts,omitempty"`
+	Vault             *vaultConfig      `yaml:"vault,omitempty"`
+	Hooks             hooksConfig       `yaml:"hooks,omitempty"`
+}
+
+// nameData is what a job's name_template is executed with for each
+// target.  Name is the target's Name tag, or its ID if it has none.
+type nameData struct {
+	Job  string
+	ID   string
+	Name string
+}
+
+// flagJob returns the job described by the flags and the top level of the
+// config, which is how every backup was configured before jobs existed.
+func flagJob(c config) jobConfig {
+	var name = *imageName
+	if name == "" {
+		name = "default"
+	}
+	return jobConfig{
+		Name:              name,
+		InstanceID:        *instanceID,
+		InstanceTags:      *instanceTags,
+		InstanceFilters:   c.InstanceFilters,
+		VolumeID:          *volumeID,
+		VolumeTags:        *volumeTags,
+		VolumeFilters:     c.VolumeFilters,
+		ImageName:         *imageName,
+		Regions:           []string{*awsRegion},
+		TimeToSave:        duration{Duration: *timeToSave},
+		KeepLast:          *keepLast,
+		Retention:         c.Retention,
+		Tags:              c.Tags,
+		Filters:           c.Filters,
+		Mode:              *mode,
+		NoReboot:          *noReboot,
+		ExcludeBootVolume: *excludeBootVolume,
+		ExcludeDevices:    splitList(*excludeDevices),
+		Copies:            c.Copies,
+		ShareAccounts:     c.ShareAccounts,
+		Vault:             c.Vault,
+		Hooks:             c.Hooks,
+	}
+}
+
+// selectJobs returns the jobs this run backs up: the one called name, or
+// every job in the config, or the job given by the flags when a target is
+// given on the command line or the config has no jobs.
+func selectJobs(c config, name string) ([]jobConfig, error) {
+	if keys := c.jobKeys(); len(c.Jobs) > 0 && len(keys) > 0 {
+		return nil, fmt.Errorf(
+			"%s belong in a job when jobs are configured",
+			strings.Join(keys, ", "),
+		)
+	}
+	var jobs = c.Jobs
+	switch {
+	case name != "" && targetFlags():
+		return nil, fmt.Errorf("job cannot be combined with instance or volume flags")
+	case name != "":
+		jobs = nil
+		for _, j := range c.Jobs {
+			if j.Name == name {
+				jobs = []jobConfig{j}
+			}
+		}
+		if jobs == nil {
+			return nil, fmt.Errorf("No job named %q in the config", name)
+		}
+	case targetFlags() || len(c.Jobs) == 0:
+		jobs = []jobConfig{flagJob(c)}
+	}
+	var seen = map[string]bool{}
+	for _, j := range c.Jobs {
+		if seen[j.Name] {
+			return nil, fmt.Errorf("Job %q is configured more than once", j.Name)
+		}
+		seen[j.Name] = true
+	}
+	for _, j := range jobs {
+		if err := j.validate(); err != nil {
+			return nil, fmt.Errorf("Job %q: %s", j.Name, err.Error())
+		}
+	}
+	return jobs, nil
+}
+
+// targetFlags reports whether a target is given on the command line.
+func targetFlags() bool {
+	return *instanceID != "" || *instanceTags != "" ||
+		*volumeID != "" || *volumeTags != ""
+}
+
+// validate reports the first problem with j that can be found without
+// calling AWS.
+func (j jobConfig) validate() error {
+	if j.Name == "" {
+		return fmt.Errorf("Jobs must have a name")
+	}
+	instances := j.InstanceID != "" || j.InstanceTags != "" ||
+		len(j.InstanceFilters) > 0
+	if !instances && !j.volumes() {
+		return fmt.Errorf(
+			"Must provide instance_id, instance_tags, instance_filters, volume_id, volume_tags or volume_filters",
+		)
+	}
+	if instances && j.volumes() {
+		return fmt.Errorf("Instances and volumes cannot be backed up in the same job")
+	}
+	if j.NameTemplate != "" {
+		if j.ImageName != "" {
+			return fmt.Errorf("image_name and name_template cannot both be set")
+		}
+		if _, err := j.nameTemplate(); err != nil {
+			return err
+		}
+	} else if ((j.InstanceID != "" || j.VolumeID != "") && j.ImageName == "") ||
+		(j.ImageName != "" && len([]rune(j.ImageName)) < 4) {
+		return fmt.Errorf("Must provide image Name at least 4 characters in length")
+	}
+	if j.TimeToSave.Duration < 0 || j.KeepLast < 0 {
+		return fmt.Errorf("time_to_save and keep_last must not be negative")
+	}
+	// every region would copy its backups into the same regions, under the
+	// same name and policy tag, and prune each other's copies there
+	if len(j.Regions) > 1 && len(j.Copies) > 0 {
+		return fmt.Errorf("copies cannot be used with more than one region")
+	}
+	if len(j.Regions) > 1 && j.Vault != nil && j.Vault.Region != "" {
+		return fmt.Errorf("Vault region cannot be set with more than one region")
+	}
+	if _, err := snapshot.ParseTagSelectors(j.InstanceTags); err != nil {
+		return err
+	}
+	if _, err := snapshot.ParseTagSelectors(j.VolumeTags); err != nil {
+		return err
+	}
+	return nil
+}
+
+// volumes reports whether j backs up volumes rather than instances.
+func (j jobConfig) volumes() bool {
+	return j.VolumeID != "" || j.VolumeTags != "" || len(j.VolumeFilters) > 0
+}
+
+// regions returns the regions j backs up in.
+func (j jobConfig) regions() []string {
+	if len(j.Regions) == 0 {
+		return []string{*awsRegion}
+	}
+	return j.Regions
+}
+
+func (j jobConfig) nameTemplate() (*template.Template, error) {
+	return template.New(j.Name).Option("missingkey=error").Parse(j.NameTemplate)
+}
+
+// options turns j into the options shared by every backup it takes in
+// region.  ImageName is left for the caller to fill in.  Backups of
+// volumes always use snapshot.ModeVolume.
+func (j jobConfig) options(
+	sess *session.Session,
+	region string,
+) (snapshot.Options, error) {
+	var opts = snapshot.Options{
+		Region:        region,
+		TimeToSave:    j.timeToSave(),
+		KeepLast:      j.KeepLast,
+		Schedule:      j.Retention,
+		Tags:          j.Tags,
+		Filters:       toEC2Filters(j.Filters),
+		WaitTimeout:   *waitTimeout,
+		ShareAccounts: j.ShareAccounts,
+		Retry:         retryPolicy(),
+		NoReboot:      j.NoReboot,
+		Hooks:         j.Hooks.hooks(),
+
+		Mode:              j.Mode,
+		ExcludeBootVolume: j.ExcludeBootVolume,
+		ExcludeDevices:    j.ExcludeDevices,
+	}
+	if j.volumes() {
+		opts.Mode = snapshot.ModeVolume
+	}
+	copies, err := getCopies(j.Copies, region)
+	if err != nil {
+		return opts, err
+	}
+	for _, target := range copies {
+		opts.Copies = append(opts.Copies, snapshot.CopyTarget{
+			Client:   newEC2(sess, target.Region),
+			Region:   target.Region,
+			KmsKeyID: target.KmsKeyID,
+		})
+	}
+	if j.Vault != nil {
+		if err := j.Vault.validate(); err != nil {
+			return opts, err
+		}
+		opts.Copies = append(
+			opts.Copies,
+			j.Vault.target(sess, region, opts.TimeToSave),
+		)
+	}
+	// validate once up front rather than failing every backup the same way
+	check := opts
+	check.ImageName = "validate"
+	return opts, check.Validate()
+}
+
+func (j jobConfig) timeToSave() int64 {
+	if j.TimeToSave.IsZero() {
+		return int64(*timeToSave / time.Second)
+	}
+	return j.TimeToSave.seconds()
+}
+
+// targets returns the ID of everything j backs up, mapped to the name of
+// its backups.
+func (j jobConfig) targets(
+	ctx context.Context,
+	client ec2iface.EC2API,
+) (map[string]string, error) {
+	var names map[string]string
+	switch {
+	case j.InstanceID != "":
+		names = map[string]string{j.InstanceID: j.InstanceID}
+	case j.VolumeID != "":
+		names = map[string]string{j.VolumeID: j.VolumeID}
+	case j.volumes():
+		filters, _ := snapshot.ParseTagSelectors(j.VolumeTags)
+		filters = append(filters, toEC2Filters(j.VolumeFilters)...)
+		found, err := snapshot.FindVolumes(ctx, client, filters)
+		if err != nil {
+			return nil, err
+		}
+		names = snapshot.VolumeBackupNames("", found)
+	default:
+		filters, _ := snapshot.ParseTagSelectors(j.InstanceTags)
+		filters = append(filters, toEC2Filters(j.InstanceFilters)...)
+		found, err := snapshot.FindInstances(ctx, client, filters)
+		if err != nil {
+			return nil, err
+		}
+		names = snapshot.InstanceImageNames("", found)
+	}
+	return j.backupNames(names)
+}
+
+// backupNames names the backup of each target in names, which maps each
+// target's ID to its Name tag or ID, after name_template or image_name.
+// Two targets of one job must never share a name, or each would prune
+// the other's backups.
+func (j jobConfig) backupNames(names map[string]string) (map[string]string, error) {
+	var (
+		result = map[string]string{}
+		owners = map[string]string{}
+	)
+	tmpl, err := j.nameTemplate()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range sortedKeys(names) {
+		var name = names[id]
+		switch {
+		case j.NameTemplate != "":
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, nameData{j.Name, id, name}); err != nil {
+				return nil, err
+			}
+			name = buf.String()
+		case j.InstanceID != "" || j.VolumeID != "":
+			name = j.ImageName
+		case j.ImageName != "":
+			name = j.ImageName + "." + name
+		}
+		if len([]rune(name)) < 4 {
+			return nil, fmt.Errorf(
+				"Name %q of %s must be at least 4 characters in length",
+				name,
+				id,
+			)
+		}
+		if other, ok := owners[name]; ok {
+			return nil, fmt.Errorf(
+				"%s and %s would both be backed up as %q",
+				other,
+				id,
+				name,
+			)
+		}
+		owners[name] = id
+		result[id] = name
+	}
+	return result, nil
+}
+
+// target is one thing a job backs up in one region, and the name of its
+// backups there.
+type target struct {
+	job    string
+	id     string
+	name   string
+	region string
+}
+
+// eachTarget calls fn with a Client for the backups of every target of j,
+// in each of its regions.  Failures are logged and counted; it returns how
+// many targets it tried and how many of them failed.
+func eachTarget(
+	ctx context.Context,
+	sess *session.Session,
+	j jobConfig,
+	fn func(svc *snapshot.Client, t target) error,
+) (int, int) {
+	var total, failed int
+	for _, region := range j.regions() {
+		client := regionClient(sess, region)
+		opts, err := j.options(sess, region)
+		if err != nil {
+			log.Print("Job ", j.Name, " in ", region, " failed with message: ", err.Error())
+			total++
+			failed++
+			continue
+		}
+		targets, err := j.targets(ctx, client)
+		if err != nil {
+			log.Print("Job ", j.Name, " in ", region, " failed with message: ", err.Error())
+			total++
+			failed++
+			continue
+		}
+		for _, id := range sortedKeys(targets) {
+			total++
+			if ctx.Err() != nil {
+				log.Print("Skipping ", id, " b/c of ", ctx.Err().Error())
+				failed++
+				continue
+			}
+			opts.ImageName = targets[id]
+			svc, err := snapshot.New(client, opts)
+			if err == nil {
+				err = fn(svc, target{j.Name, id, targets[id], region})
+			}
+			if err != nil {
+				log.Print("Job ", j.Name, " for ", id, " failed with message: ", err.Error())
+				failed++
+			}
+		}
+	}
+	return total, failed
+}
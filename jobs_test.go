@@ -0,0 +1,208 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+)
+
+func TestSelectJobs(t *testing.T) {
+	var (
+		web = jobConfig{Name: "web", InstanceTags: "Role=web"}
+		db  = jobConfig{Name: "db", InstanceID: "i-1234abc", ImageName: "db01.backup"}
+	)
+
+	var tests = []struct {
+		c      config
+		name   string
+		expect []string
+		fail   bool
+	}{
+		{c: config{Jobs: []jobConfig{web, db}}, expect: []string{"web", "db"}},
+		{c: config{Jobs: []jobConfig{web, db}}, name: "db", expect: []string{"db"}},
+		{c: config{Jobs: []jobConfig{web, db}}, name: "cache", fail: true},
+		{c: config{Jobs: []jobConfig{web, web}}, fail: true},
+		{
+			c: config{
+				Jobs:            []jobConfig{web},
+				InstanceFilters: []filterConfig{{Key: "tag:Backup", Values: []string{"daily"}}},
+			},
+			fail: true,
+		},
+		// jobs do not inherit the top level, so it is refused alongside them
+		{
+			c: config{
+				Jobs:    []jobConfig{web},
+				Filters: []filterConfig{{Key: "owner-id", Values: []string{"self"}}},
+			},
+			fail: true,
+		},
+		{c: config{Jobs: []jobConfig{web}, Vault: &vaultConfig{}}, fail: true},
+		{c: config{Jobs: []jobConfig{web}, Retention: snapshot.Schedule{Daily: 7}}, fail: true},
+		{c: config{Jobs: []jobConfig{{Name: "empty"}}}, fail: true},
+		// without jobs the flags and top level filters are the job
+		{
+			c: config{
+				InstanceFilters: []filterConfig{{Key: "tag:Backup", Values: []string{"daily"}}},
+			},
+			expect: []string{"default"},
+		},
+		{c: config{}, fail: true},
+	}
+	for _, test := range tests {
+		jobs, err := selectJobs(test.c, test.name)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected an error for %+v but got nil", test.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected nil for %+v but got %v", test.c, err)
+			continue
+		}
+		var names = []string{}
+		for _, j := range jobs {
+			names = append(names, j.Name)
+		}
+		if !reflect.DeepEqual(names, test.expect) {
+			t.Errorf("Expected jobs %v got %v", test.expect, names)
+		}
+	}
+}
+
+func TestJobValidate(t *testing.T) {
+	var failing = []jobConfig{
+		{InstanceID: "i-1234abc", ImageName: "web01.backup"},
+		{Name: "web", InstanceID: "i-1234abc"},
+		{Name: "web", InstanceID: "i-1234abc", ImageName: "web"},
+		{Name: "web", InstanceTags: "Role=web", VolumeTags: "Backup=daily"},
+		{Name: "web", InstanceTags: "=web"},
+		{Name: "web", InstanceTags: "Role=web", KeepLast: -1},
+		{Name: "web", InstanceTags: "Role=web", NameTemplate: "{{.Name"},
+		{
+			Name:         "web",
+			InstanceTags: "Role=web",
+			ImageName:    "nightly",
+			NameTemplate: "{{.Name}}",
+		},
+		{
+			Name:         "web",
+			InstanceTags: "Role=web",
+			Regions:      []string{"us-east-1", "eu-west-1"},
+			Copies:       []copyConfig{{Region: "us-west-2"}},
+		},
+		{
+			Name:         "web",
+			InstanceTags: "Role=web",
+			Regions:      []string{"us-east-1", "eu-west-1"},
+			Vault: &vaultConfig{
+				RoleARN: "arn:aws:iam::210987654321:role/vault",
+				Region:  "us-west-2",
+			},
+		},
+	}
+	for _, j := range failing {
+		if err := j.validate(); err == nil {
+			t.Errorf("Expected an error for %+v but got nil", j)
+		}
+	}
+
+	var passing = []jobConfig{
+		{Name: "web", InstanceID: "i-1234abc", ImageName: "web01.backup"},
+		{Name: "web", InstanceID: "i-1234abc", NameTemplate: "{{.Job}}.{{.ID}}"},
+		{Name: "web", InstanceTags: "Role=web"},
+		{Name: "data", VolumeTags: "Backup=daily", ImageName: "nightly"},
+		{
+			Name:         "web",
+			InstanceTags: "Role=web",
+			Regions:      []string{"us-east-1", "eu-west-1"},
+			Vault:        &vaultConfig{RoleARN: "arn:aws:iam::210987654321:role/vault"},
+		},
+	}
+	for _, j := range passing {
+		if err := j.validate(); err != nil {
+			t.Errorf("Expected nil for %+v but got %v", j, err)
+		}
+	}
+}
+
+func TestJobBackupNames(t *testing.T) {
+	var names = map[string]string{
+		"i-1": "web01",
+		"i-2": "web02",
+	}
+
+	var tests = []struct {
+		job    jobConfig
+		names  map[string]string
+		expect map[string]string
+		fail   bool
+	}{
+		{
+			job:    jobConfig{Name: "web"},
+			names:  names,
+			expect: map[string]string{"i-1": "web01", "i-2": "web02"},
+		},
+		{
+			job:    jobConfig{Name: "web", ImageName: "nightly"},
+			names:  names,
+			expect: map[string]string{"i-1": "nightly.web01", "i-2": "nightly.web02"},
+		},
+		{
+			job:    jobConfig{Name: "web", InstanceID: "i-1", ImageName: "web01.backup"},
+			names:  map[string]string{"i-1": "i-1"},
+			expect: map[string]string{"i-1": "web01.backup"},
+		},
+		{
+			job:    jobConfig{Name: "web", NameTemplate: "{{.Job}}-{{.Name}}-{{.ID}}"},
+			names:  names,
+			expect: map[string]string{"i-1": "web-web01-i-1", "i-2": "web-web02-i-2"},
+		},
+		// every target would share one policy and prune the others' backups
+		{
+			job:   jobConfig{Name: "web", NameTemplate: "{{.Job}}.nightly"},
+			names: names,
+			fail:  true,
+		},
+		{
+			job:   jobConfig{Name: "web", NameTemplate: "{{.Name}}"},
+			names: map[string]string{"i-1": "db"},
+			fail:  true,
+		},
+		{
+			job:   jobConfig{Name: "web", NameTemplate: "{{.Missing}}"},
+			names: names,
+			fail:  true,
+		},
+	}
+	for _, test := range tests {
+		result, err := test.job.backupNames(test.names)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected an error for %+v but got nil", test.job)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected nil for %+v but got %v", test.job, err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+}
+
+func TestGetCopies(t *testing.T) {
+	var copies = []copyConfig{{Region: "us-west-2"}}
+	if _, err := getCopies(copies, "us-east-1"); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if _, err := getCopies(copies, "us-west-2"); err == nil {
+		t.Error("Expected an error for a copy into the job's own region")
+	}
+	if _, err := getCopies([]copyConfig{{}}, "us-east-1"); err == nil {
+		t.Error("Expected an error for a copy without a region")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+)
+
+// Formats list can print the backups in.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// Whether the next prune keeps a backup.
+const (
+	statusKeep  = "keep"
+	statusPrune = "prune"
+)
+
+// inventoryItem is one backup list prints: a snapshot.Backup with the job
+// and target it belongs to, its age and whether the next prune keeps it.
+// ID is empty for snapshot sets.
+type inventoryItem struct {
+	Job         string    `json:"job"`
+	Target      string    `json:"target"`
+	ID          string    `json:"id,omitempty"`
+	Name        string    `json:"name"`
+	Region      string    `json:"region"`
+	Account     string    `json:"account,omitempty"`
+	Created     time.Time `json:"created"`
+	Age         string    `json:"age"`
+	SizeGiB     int64     `json:"size_gib"`
+	State       string    `json:"state"`
+	SnapshotIDs []string  `json:"snapshot_ids"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// inventoryColumns heads the table and CSV, in the order of
+// inventoryItem.row.
+var inventoryColumns = []string{
+	"job",
+	"target",
+	"id",
+	"name",
+	"region",
+	"account",
+	"created",
+	"age",
+	"size_gib",
+	"state",
+	"snapshot_ids",
+	"status",
+	"reason",
+}
+
+// newInventoryItem returns b, a backup of t, as list prints it at now.
+func newInventoryItem(t target, b snapshot.Backup, now time.Time) inventoryItem {
+	var item = inventoryItem{
+		Job:         t.job,
+		Target:      t.id,
+		ID:          b.ImageID,
+		Name:        b.Name,
+		Region:      b.Region,
+		Account:     b.Account,
+		Created:     b.Created.UTC(),
+		Age:         formatAge(now.Sub(b.Created)),
+		SizeGiB:     b.SizeGiB,
+		State:       b.State,
+		SnapshotIDs: b.SnapshotIDs,
+		Status:      statusPrune,
+		Reason:      b.Reason,
+	}
+	if b.Keep {
+		item.Status = statusKeep
+	}
+	if item.SnapshotIDs == nil {
+		item.SnapshotIDs = []string{}
+	}
+	return item
+}
+
+// formatAge writes d like formatDuration, to the minute, or to the hour
+// once it is a day or more.
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return formatDuration(d.Truncate(time.Hour))
+	}
+	return formatDuration(d.Truncate(time.Minute))
+}
+
+// row returns the fields of i in the order of inventoryColumns.
+func (i inventoryItem) row() []string {
+	return []string{
+		i.Job,
+		i.Target,
+		i.ID,
+		i.Name,
+		i.Region,
+		i.Account,
+		i.Created.Format(time.RFC3339),
+		i.Age,
+		strconv.FormatInt(i.SizeGiB, 10),
+		i.State,
+		strings.Join(i.SnapshotIDs, ","),
+		i.Status,
+		i.Reason,
+	}
+}
+
+// checkFormat reports whether list can print in format.
+func checkFormat(format string) error {
+	switch format {
+	case formatTable, formatJSON, formatCSV:
+		return nil
+	}
+	return fmt.Errorf(
+		"Unknown format %q, must be %s, %s or %s",
+		format,
+		formatTable,
+		formatJSON,
+		formatCSV,
+	)
+}
+
+// writeInventory writes items to w in format.  Empty cells of the table
+// read "-".
+func writeInventory(w io.Writer, format string, items []inventoryItem) error {
+	switch format {
+	case formatJSON:
+		if items == nil {
+			items = []inventoryItem{}
+		}
+		dump, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(dump, '\n'))
+		return err
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(inventoryColumns)
+		for _, item := range items {
+			cw.Write(item.row())
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(inventoryColumns, "\t")))
+	for _, item := range items {
+		row := item.row()
+		for i := range row {
+			if row[i] == "" {
+				row[i] = "-"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
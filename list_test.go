@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+)
+
+func TestFormatAge(t *testing.T) {
+	var tests = []struct {
+		age    time.Duration
+		expect string
+	}{
+		{90*time.Minute + 20*time.Second, "1h30m"},
+		{9*24*time.Hour + 5*time.Hour + 12*time.Minute, "1w2d5h"},
+		{20 * time.Second, "0s"},
+	}
+	for _, test := range tests {
+		if result := formatAge(test.age); result != test.expect {
+			t.Errorf("Expected %q for %s got %q", test.expect, test.age, result)
+		}
+	}
+}
+
+func TestWriteInventory(t *testing.T) {
+	var (
+		now     = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.UTC)
+		web     = target{job: "web", id: "i-1234abc", name: "web01", region: "us-east-1"}
+		data    = target{job: "data", id: "vol-1234abc", name: "data01", region: "us-east-1"}
+		backups = []inventoryItem{
+			newInventoryItem(web, snapshot.Backup{
+				ImageID:     "ami-123456a",
+				Name:        "web01.20160315100000",
+				SnapshotIDs: []string{"snap-1", "snap-2"},
+				Region:      "us-east-1",
+				Created:     now.Add(-2 * time.Hour),
+				State:       "available",
+				SizeGiB:     108,
+				Keep:        true,
+				Reason:      "newest 1",
+			}, now),
+			newInventoryItem(data, snapshot.Backup{
+				Name:    "data01.20160301120000",
+				Region:  "us-east-1",
+				Created: now.Add(-14 * 24 * time.Hour),
+				State:   "completed",
+			}, now),
+		}
+	)
+
+	var tests = []struct {
+		format string
+		expect string
+	}{
+		{
+			formatTable,
+			`JOB   TARGET       ID           NAME                   REGION     ACCOUNT  CREATED               AGE  SIZE_GIB  STATE      SNAPSHOT_IDS   STATUS  REASON
+web   i-1234abc    ami-123456a  web01.20160315100000   us-east-1  -        2016-03-15T10:00:00Z  2h   108       available  snap-1,snap-2  keep    newest 1
+data  vol-1234abc  -            data01.20160301120000  us-east-1  -        2016-03-01T12:00:00Z  2w   0         completed  -              prune   -
+`,
+		},
+		{
+			formatCSV,
+			`job,target,id,name,region,account,created,age,size_gib,state,snapshot_ids,status,reason
+web,i-1234abc,ami-123456a,web01.20160315100000,us-east-1,,2016-03-15T10:00:00Z,2h,108,available,"snap-1,snap-2",keep,newest 1
+data,vol-1234abc,,data01.20160301120000,us-east-1,,2016-03-01T12:00:00Z,2w,0,completed,,prune,
+`,
+		},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := writeInventory(&out, test.format, backups); err != nil {
+			t.Fatalf("Expected nil but got %v", err)
+		}
+		if out.String() != test.expect {
+			t.Errorf("Expected %s output\n%s\ngot\n%s", test.format, test.expect, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeInventory(&out, formatJSON, backups); err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	var result []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Expected JSON but got %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 backups got %d", len(result))
+	}
+	var expect = map[string]interface{}{
+		"job":          "web",
+		"target":       "i-1234abc",
+		"id":           "ami-123456a",
+		"name":         "web01.20160315100000",
+		"region":       "us-east-1",
+		"created":      "2016-03-15T10:00:00Z",
+		"age":          "2h",
+		"size_gib":     float64(108),
+		"state":        "available",
+		"snapshot_ids": []interface{}{"snap-1", "snap-2"},
+		"status":       "keep",
+		"reason":       "newest 1",
+	}
+	if !reflect.DeepEqual(result[0], expect) {
+		t.Errorf("Expected %v got %v", expect, result[0])
+	}
+	if ids, ok := result[1]["snapshot_ids"].([]interface{}); !ok || len(ids) != 0 {
+		t.Errorf("Expected an empty list of snapshot IDs got %v", result[1]["snapshot_ids"])
+	}
+
+	out.Reset()
+	if err := writeInventory(&out, formatJSON, nil); err != nil || out.String() != "[]\n" {
+		t.Errorf("Expected [] for no backups got %q, %v", out.String(), err)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range []string{formatTable, formatJSON, formatCSV} {
+		if err := checkFormat(format); err != nil {
+			t.Errorf("Expected nil for %s but got %v", format, err)
+		}
+	}
+	if err := checkFormat("yaml"); err == nil {
+		t.Error("Expected an error for yaml")
+	}
+}
@@ -1,47 +1,112 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 
-	"github.com/allanliu/easylogger"
+	"github.com/PermissionData/ec2_snapshot/snapshot"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
-func init() {
+func main() {
 	flag.Parse()
-	easylogger.InitializeLog()
+	flag.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = true
+	})
+	cmd, args, err := parseCommand(flag.Args())
+	switch {
+	case err == flag.ErrHelp:
+		return
+	case err != nil:
+		os.Exit(2)
+	}
+	log.SetOutput(os.Stdout)
+	sources, err := envSettings()
+	if err == nil {
+		err = cmd.run(sources, args)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func main() {
+// retryPolicy returns how EC2 calls are retried, from the flags.
+func retryPolicy() snapshot.RetryPolicy {
+	return snapshot.RetryPolicy{
+		MaxAttempts: *retryAttempts,
+		Budget:      *retryBudget,
+	}
+}
+
+// regionClient returns an EC2 client for region that retries according to
+// retryPolicy.
+func regionClient(sess *session.Session, region string) ec2iface.EC2API {
+	return snapshot.WithRetry(newEC2(sess, region), retryPolicy())
+}
+
+// newEC2 returns an EC2 client for region, with cfgs applied on top.  The
+// SDK's own retries are turned off, since every client ends up wrapped by
+// snapshot.WithRetry and the two would multiply each other's attempts.
+func newEC2(sess *session.Session, region string, cfgs ...*aws.Config) *ec2.EC2 {
+	cfg := &aws.Config{Region: aws.String(region), MaxRetries: aws.Int(0)}
+	return ec2.New(sess, append([]*aws.Config{cfg}, cfgs...)...)
+}
+
+// runContext returns the context for the whole run.  It is cancelled on
+// SIGINT or SIGTERM and, when timeout is set, once it expires.  Only the
+// first signal is caught, so a second one kills the process as usual.
+func runContext() (context.Context, context.CancelFunc) {
 	var (
-		svc    *svcEC2
-		params *ec2.CreateImageInput
-		resp   string
-		err    error
+		ctx    = context.Background()
+		cancel context.CancelFunc
 	)
-	if *instanceID == "" {
-		panic("Must provide InstanceID")
-	}
-	if *imageName == "" || len([]rune(*imageName)) < 4 {
-		panic("Must provide image Name at least 4 characters in length")
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(
+			ctx,
+			*timeout,
+		)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
-	svc = &svcEC2{
-		svc: ec2.New(session.New(), &aws.Config{Region: aws.String(*awsRegion)}),
-		imageNameWithoutTimestamp: *imageName,
-		imageName:                 createNameWithTimestamp(*imageName),
-		timeToSave:                *timeToSave,
-		filter:                    getFilter(),
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Print("Received ", sig, ", stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
 	}
-	params = &ec2.CreateImageInput{
-		Name:        aws.String(svc.imageName),
-		InstanceId:  aws.String(*instanceID),
-		Description: aws.String("This is a test"),
-		DryRun:      aws.Bool(false),
+	return result
+}
+
+func sortedKeys(m map[string]string) []string {
+	var result = []string{}
+	for k := range m {
+		result = append(result, k)
 	}
-	resp, err = svc.createImage(params)
-	easylogger.Log("Create Successful...")
-	easylogger.LogFatal(err)
-	easylogger.Log("Success with message: ", resp)
+	sort.Strings(result)
+	return result
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestNewEC2Attempts(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`<Response><Errors><Error><Code>RequestLimitExceeded</Code>` +
+			`<Message>Request limit exceeded.</Message></Error></Errors></Response>`))
+	}))
+	defer server.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+
+	// every attempt WithRetry makes is exactly one request, so the attempt
+	// budget holds and 1 really turns retrying off
+	for _, attempts := range []int{1, 3} {
+		atomic.StoreInt32(&calls, 0)
+		svc := snapshot.WithRetry(newEC2(sess, "us-east-1"), snapshot.RetryPolicy{
+			MaxAttempts: attempts,
+			BaseDelay:   time.Millisecond,
+		})
+		_, err := svc.DescribeImagesWithContext(context.Background(), &ec2.DescribeImagesInput{})
+		if err == nil {
+			t.Errorf("Expected an error with %d attempts but got nil", attempts)
+		}
+		if n := atomic.LoadInt32(&calls); int(n) != attempts {
+			t.Errorf("Expected %d requests with %d attempts got %d", attempts, attempts, n)
+		}
+	}
+}
+
+func TestRunContextSignal(t *testing.T) {
+	ctx, cancel := runContext()
+	defer cancel()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected SIGTERM to cancel the run")
+	}
+}
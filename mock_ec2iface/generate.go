@@ -0,0 +1,4 @@
+package mock_ec2iface
+
+// Regenerate the mock after upgrading the aws-sdk-go version in go.mod.
+//go:generate mockgen -destination mock_ec2iface.go -package mock_ec2iface github.com/aws/aws-sdk-go/service/ec2/ec2iface EC2API
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix starts the environment variable of every setting, e.g.
+// EC2_SNAPSHOT_TIME_TO_SAVE for time-to-save.
+const envPrefix = "EC2_SNAPSHOT_"
+
+var (
+	durationPattern     = regexp.MustCompile(`^(-)?((?:[0-9]+[wdhms])+)$`)
+	durationPartPattern = regexp.MustCompile(`[0-9]+[wdhms]`)
+	durationUnits       = []struct {
+		unit string
+		size time.Duration
+	}{
+		{"w", 7 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+)
+
+// parseDuration reads a duration as plain seconds, which every duration
+// flag used to take, or as whole weeks, days, hours, minutes and seconds
+// such as 2w, 7d, 36h or 1d12h.  Durations that do not fit in a
+// time.Duration are refused rather than wrapped around.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	tooLong := fmt.Errorf("%q is too long a duration", s)
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if seconds > math.MaxInt64/int64(time.Second) ||
+			seconds < -math.MaxInt64/int64(time.Second) {
+			return 0, tooLong
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	m := durationPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a duration such as 3600, 36h, 7d or 2w", s)
+	}
+	var result time.Duration
+	for _, part := range durationPartPattern.FindAllString(m[2], -1) {
+		var size time.Duration
+		for _, u := range durationUnits {
+			if u.unit == part[len(part)-1:] {
+				size = u.size
+			}
+		}
+		n, err := strconv.ParseInt(part[:len(part)-1], 10, 64)
+		if err != nil || n > math.MaxInt64/int64(size) ||
+			result > math.MaxInt64-time.Duration(n)*size {
+			return 0, tooLong
+		}
+		result += time.Duration(n) * size
+	}
+	if m[1] != "" {
+		result = -result
+	}
+	return result, nil
+}
+
+// formatDuration writes d the way parseDuration reads it, in the largest
+// units that fit.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d < 0:
+		return "-" + formatDuration(-d)
+	case d%time.Second != 0:
+		return d.String()
+	}
+	var result string
+	for _, u := range durationUnits {
+		if n := d / u.size; n > 0 {
+			result += strconv.FormatInt(int64(n), 10) + u.unit
+			d -= n * u.size
+		}
+	}
+	return result
+}
+
+// durationValue is a flag.Value for durations written for parseDuration.
+type durationValue time.Duration
+
+// durationFlag defines a duration flag, like flag.Duration but in the
+// units parseDuration reads.
+func durationFlag(name string, value time.Duration, usage string) *time.Duration {
+	var p = new(time.Duration)
+	*p = value
+	flag.Var((*durationValue)(p), name, usage)
+	return p
+}
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return formatDuration(time.Duration(*d))
+}
+
+func (d *durationValue) Get() interface{} {
+	return time.Duration(*d)
+}
+
+// duration is a duration in the config, written like the duration flags.
+// A value that cannot be read is kept in err, so checkConfig can report
+// it with its line rather than the whole file failing to load.
+type duration struct {
+	time.Duration
+	err error
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	d.Duration, d.err = parseDuration(s)
+	return nil
+}
+
+func (d duration) MarshalYAML() (interface{}, error) {
+	return formatDuration(d.Duration), nil
+}
+
+// IsZero lets omitempty leave out unset durations.
+func (d duration) IsZero() bool {
+	return d.Duration == 0 && d.err == nil
+}
+
+// seconds returns d in whole seconds, the unit snapshot.Options takes.
+func (d duration) seconds() int64 {
+	return int64(d.Duration / time.Second)
+}
+
+// Sources a setting can come from, in order of precedence.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceConfig  = "config"
+	sourceDefault = "default"
+)
+
+// commandLine holds the flags given on the command line, before any were
+// set from the environment or config.
+var commandLine = map[string]bool{}
+
+// settingSources records where each setting, named like its flag, got its
+// value from, e.g. "env EC2_SNAPSHOT_TIME_TO_SAVE".
+type settingSources map[string]string
+
+// envName returns the environment variable of the setting called name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// settingName returns the setting a settings key in the config, such as
+// time_to_save, stands for.
+func settingName(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// envSettings sets every flag not given on the command line from its
+// environment variable, if that is set.  It runs before the config is
+// read, so that EC2_SNAPSHOT_CONFIG can point at it.
+func envSettings() (settingSources, error) {
+	var (
+		sources = settingSources{}
+		errs    = []string{}
+	)
+	flag.VisitAll(func(f *flag.Flag) {
+		if commandLine[f.Name] {
+			sources[f.Name] = sourceFlag
+			return
+		}
+		sources[f.Name] = sourceDefault
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := flag.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", envName(f.Name), err.Error()))
+			return
+		}
+		sources[f.Name] = sourceEnv + " " + envName(f.Name)
+	})
+	if len(errs) > 0 {
+		return sources, fmt.Errorf("Invalid environment:\n%s", strings.Join(errs, "\n"))
+	}
+	return sources, nil
+}
+
+// applyConfig sets every flag still at its default from the settings in
+// the config.  checkConfig has already vetted them.
+func (s settingSources) applyConfig(settings map[string]string) error {
+	for _, key := range sortedKeys(settings) {
+		name := settingName(key)
+		if s[name] != sourceDefault {
+			continue
+		}
+		if err := flag.Set(name, settings[key]); err != nil {
+			return fmt.Errorf("settings.%s: %s", key, err.Error())
+		}
+		s[name] = sourceConfig + " settings." + key
+	}
+	return nil
+}
+
+// checkSetting reports whether value can be given to the setting key in
+// the config.
+func checkSetting(key string, value string) error {
+	var name = settingName(key)
+	f := flag.Lookup(name)
+	if f == nil || name == "config" {
+		return fmt.Errorf("unknown setting")
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return nil
+	}
+	var err error
+	switch getter.Get().(type) {
+	case bool:
+		_, err = strconv.ParseBool(value)
+	case int:
+		_, err = strconv.Atoi(value)
+	case int64:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case time.Duration:
+		_, err = parseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, name)
+	}
+	return nil
+}
+
+// showConfig writes the effective settings, where each came from, and the
+// jobs they add up to, if any.
+func showConfig(w io.Writer, sources settingSources, jobs []jobConfig) error {
+	var names = []string{}
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, flag.Lookup(name).Value.String(), sources[name])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	dump, err := yaml.Marshal(struct {
+		Jobs []jobConfig `yaml:"jobs"`
+	}{jobs})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%s", dump)
+	return err
+}
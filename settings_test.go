@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	var tests = []struct {
+		s      string
+		expect time.Duration
+		format string
+		fail   bool
+	}{
+		{s: "604800", expect: 7 * 24 * time.Hour, format: "1w"},
+		{s: "7d", expect: 7 * 24 * time.Hour, format: "1w"},
+		{s: "2w", expect: 14 * 24 * time.Hour, format: "2w"},
+		{s: "36h", expect: 36 * time.Hour, format: "1d12h"},
+		{s: "1d12h", expect: 36 * time.Hour, format: "1d12h"},
+		{s: "90m", expect: 90 * time.Minute, format: "1h30m"},
+		{s: "0", expect: 0, format: "0s"},
+		{s: "-1", expect: -time.Second, format: "-1s"},
+		{s: "7 days", fail: true},
+		{s: "1.5h", fail: true},
+		{s: "h", fail: true},
+		{s: "", fail: true},
+		{s: "9223372037", fail: true},
+		{s: "-9223372037", fail: true},
+		{s: "15251w", fail: true},
+		{s: "99999999999999999999h", fail: true},
+		{s: "15000w1000w", fail: true},
+		{s: "9223372036s", expect: 9223372036 * time.Second, format: "15250w1d23h47m16s"},
+	}
+	for _, test := range tests {
+		result, err := parseDuration(test.s)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected an error for %q but got nil", test.s)
+			}
+			continue
+		}
+		if err != nil || result != test.expect {
+			t.Errorf("Expected %s for %q got %s, %v", test.expect, test.s, result, err)
+		}
+		if format := formatDuration(result); format != test.format {
+			t.Errorf("Expected %q got %q", test.format, format)
+		}
+	}
+}
+
+func TestCheckSetting(t *testing.T) {
+	var tests = []struct {
+		key   string
+		value string
+		fail  bool
+	}{
+		{"time_to_save", "2w", false},
+		{"time_to_save", "2 weeks", true},
+		{"keep_last", "3", false},
+		{"keep_last", "three", true},
+		{"dry_run", "true", false},
+		{"dry_run", "maybe", true},
+		{"aws_region", "eu-west-1", false},
+		{"config", "other.yml", true},
+		{"keep_latest", "3", true},
+	}
+	for _, test := range tests {
+		if err := checkSetting(test.key, test.value); test.fail != (err != nil) {
+			t.Errorf("Expected failure %t for %s=%q but got %v", test.fail, test.key, test.value, err)
+		}
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	defer func(keep int, save time.Duration, region string) {
+		*keepLast, *timeToSave, *awsRegion = keep, save, region
+	}(*keepLast, *timeToSave, *awsRegion)
+	os.Setenv("EC2_SNAPSHOT_KEEP_LAST", "5")
+	defer os.Unsetenv("EC2_SNAPSHOT_KEEP_LAST")
+
+	sources, err := envSettings()
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	err = sources.applyConfig(map[string]string{
+		"keep_last":    "3",
+		"time_to_save": "2w",
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	// the environment wins over the config, the config over defaults
+	if *keepLast != 5 || *timeToSave != 14*24*time.Hour || *awsRegion != "us-east-1" {
+		t.Errorf(
+			"Expected keep-last 5, time-to-save 2w and aws-region us-east-1 got %d, %s and %s",
+			*keepLast,
+			*timeToSave,
+			*awsRegion,
+		)
+	}
+
+	var out bytes.Buffer
+	if err := showConfig(&out, sources, []jobConfig{{Name: "web", InstanceTags: "Role=web"}}); err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	for _, expect := range []string{
+		`keep-last\s+5\s+env EC2_SNAPSHOT_KEEP_LAST`,
+		`time-to-save\s+2w\s+config settings.time_to_save`,
+		`aws-region\s+us-east-1\s+default`,
+		`- name: web\n\s+instance_tags: Role=web\n`,
+	} {
+		if !regexp.MustCompile(expect).MatchString(out.String()) {
+			t.Errorf("Expected output to match %q got\n%s", expect, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "time_to_save:") {
+		t.Errorf("Expected unset durations to be left out got\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := showConfig(&out, sources, nil); err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "SETTING") || strings.Contains(out.String(), "jobs:") {
+		t.Errorf("Expected only the settings without jobs got\n%s", out.String())
+	}
+
+	os.Setenv("EC2_SNAPSHOT_KEEP_LAST", "five")
+	if _, err := envSettings(); err == nil {
+		t.Error("Expected an error for an invalid environment variable")
+	}
+}
@@ -0,0 +1,327 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// DefaultWaitTimeout is how long Create waits for a new image when
+// Options.WaitTimeout is not set.
+const DefaultWaitTimeout = time.Hour
+
+// Options configures a Client.  ImageName is the backup's name without its
+// timestamp; it is also the policy tag that marks which existing images
+// belong to this backup.  TimeToSave is in seconds and is always honoured,
+// KeepLast and Schedule only keep more; at least one of the three must be
+// set.  Retry applies to svc and every
+// copy target's Client.  Mode is ModeImage when empty; ExcludeBootVolume
+// and ExcludeDevices, a list of device names such as /dev/sdf, only apply
+// to ModeSnapshots, which supports neither copies nor sharing.  NoReboot
+// only applies to ModeImage; Hooks run around the backup in every mode.
+type Options struct {
+	Region        string
+	ImageName     string
+	TimeToSave    int64
+	KeepLast      int
+	Schedule      Schedule
+	Tags          map[string]string
+	Filters       []*ec2.Filter
+	WaitTimeout   time.Duration
+	PollInterval  time.Duration
+	Copies        []CopyTarget
+	ShareAccounts []string
+	Retry         RetryPolicy
+	NoReboot      bool
+	Hooks         Hooks
+
+	Mode              string
+	ExcludeBootVolume bool
+	ExcludeDevices    []string
+}
+
+// Backup is an existing backup image, or snapshot set, and whether the
+// retention policy keeps it.  Reason is empty for backups the next prune
+// deletes.  A snapshot set has no ImageID; its Name is the set's name and
+// its State is completed once every snapshot in it is.  SizeGiB adds up
+// the size of the volumes the snapshots were taken of.
+type Backup struct {
+	ImageID     string
+	Name        string
+	SnapshotIDs []string
+	Region      string
+	Account     string
+	Created     time.Time
+	State       string
+	SizeGiB     int64
+	Keep        bool
+	Reason      string
+}
+
+// Validate reports the first problem with o, without calling AWS.
+func (o Options) Validate() error {
+	if o.ImageName == "" {
+		return fmt.Errorf("ImageName must be set")
+	}
+	if o.TimeToSave < 0 || o.KeepLast < 0 || o.WaitTimeout < 0 {
+		return fmt.Errorf(
+			"TimeToSave, KeepLast and WaitTimeout must not be negative",
+		)
+	}
+	if err := o.Schedule.Validate(); err != nil {
+		return err
+	}
+	if err := ValidateRetention(o.TimeToSave, o.KeepLast, o.Schedule); err != nil {
+		return err
+	}
+	if err := validateTags(o.Tags); err != nil {
+		return err
+	}
+	if err := ValidateAccountIDs(o.ShareAccounts); err != nil {
+		return err
+	}
+	if err := o.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := o.Hooks.Validate(); err != nil {
+		return err
+	}
+	switch o.Mode {
+	case "", ModeImage, ModeVolume:
+		if o.ExcludeBootVolume || len(o.ExcludeDevices) > 0 {
+			return fmt.Errorf("Volumes can only be excluded in %s mode", ModeSnapshots)
+		}
+	case ModeSnapshots:
+	default:
+		return fmt.Errorf("Unknown mode %s", o.Mode)
+	}
+	if o.NoReboot && o.Mode != "" && o.Mode != ModeImage {
+		return fmt.Errorf("NoReboot only applies in %s mode", ModeImage)
+	}
+	if (o.Mode == ModeSnapshots || o.Mode == ModeVolume) &&
+		(len(o.Copies) > 0 || len(o.ShareAccounts) > 0) {
+		return fmt.Errorf(
+			"Copies and ShareAccounts are not supported in %s mode",
+			o.Mode,
+		)
+	}
+	for _, c := range o.Copies {
+		if c.Client == nil || c.Region == "" {
+			return fmt.Errorf("Copy targets need a Client and Region")
+		}
+		if c.Account == "" {
+			continue
+		}
+		if err := ValidateAccountIDs([]string{c.Account}); err != nil {
+			return err
+		}
+		if err := c.Schedule.Validate(); err != nil {
+			return err
+		}
+		if err := ValidateRetention(c.TimeToSave, c.KeepLast, c.Schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// New returns a Client that backs up into svc's region.  Copy targets in
+// another account are added to the accounts every new backup is shared
+// with, so that they can copy it.
+func New(svc ec2iface.EC2API, opts Options) (*Client, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	var s = &Client{
+		svc:                       WithRetry(svc, opts.Retry),
+		region:                    opts.Region,
+		imageNameWithoutTimestamp: opts.ImageName,
+		imageName:                 opts.ImageName,
+		timeToSave:                opts.TimeToSave,
+		keepLast:                  opts.KeepLast,
+		tags:                      opts.Tags,
+		filter:                    opts.Filters,
+		waitTimeout:               opts.WaitTimeout,
+		pollInterval:              opts.PollInterval,
+		shareAccounts:             opts.ShareAccounts,
+		noReboot:                  opts.NoReboot,
+		hooks:                     opts.Hooks,
+		mode:                      opts.Mode,
+		excludeBootVolume:         opts.ExcludeBootVolume,
+		excludeDevices:            opts.ExcludeDevices,
+	}
+	if s.mode == "" {
+		s.mode = ModeImage
+	}
+	if !opts.Schedule.isZero() {
+		s.schedule = opts.Schedule
+	}
+	if s.waitTimeout == 0 {
+		s.waitTimeout = DefaultWaitTimeout
+	}
+	for _, c := range opts.Copies {
+		dest := *s
+		dest.svc = WithRetry(c.Client, opts.Retry)
+		dest.region = c.Region
+		dest.kmsKeyID = c.KmsKeyID
+		dest.copies = nil
+		if c.Account != "" {
+			dest.account = c.Account
+			dest.timeToSave = c.TimeToSave
+			dest.keepLast = c.KeepLast
+			dest.schedule = nil
+			if !c.Schedule.isZero() {
+				dest.schedule = c.Schedule
+			}
+			dest.filter = c.Filters
+			dest.shareAccounts = nil
+			s.shareAccounts = append(
+				append([]string{}, s.shareAccounts...),
+				c.Account,
+			)
+		}
+		s.copies = append(s.copies, &dest)
+	}
+	return s, nil
+}
+
+// Create backs up instanceID, waits for the image, shares and copies it,
+// and prunes old backups in every region it lives in.  It returns the new
+// image's ID, and a MultiError of everything that failed after the image was
+// created.  In ModeSnapshots it takes a snapshot set instead and returns
+// the set's name.  In ModeVolume instanceID is a volume ID and the new
+// snapshot's ID is returned.
+func (s *Client) Create(
+	ctx context.Context,
+	instanceID string,
+) (string, error) {
+	switch s.mode {
+	case ModeSnapshots:
+		s.nameBackup()
+		return s.createSnapshotSet(ctx, instanceID, time.Now())
+	case ModeVolume:
+		s.nameBackup()
+		return s.createVolumeSnapshot(ctx, instanceID, time.Now())
+	}
+	return s.createImage(ctx, s.createImageInput(instanceID, time.Now()))
+}
+
+// Plan returns what Create would do for instanceID without changing
+// anything.
+func (s *Client) Plan(
+	ctx context.Context,
+	instanceID string,
+) ([]PlanItem, error) {
+	if s.takesSnapshotSets() {
+		s.nameBackup()
+		return s.planSnapshotSet(ctx, instanceID)
+	}
+	return s.plan(ctx, s.createImageInput(instanceID, time.Now()))
+}
+
+// Prune deletes every backup, in this region and each copy target, that
+// the retention policy no longer keeps.
+func (s *Client) Prune(ctx context.Context) error {
+	var errs MultiError
+	for _, c := range append([]*Client{s}, s.copies...) {
+		var err error
+		if c.takesSnapshotSets() {
+			err = c.removeOldSnapshotSets(ctx)
+		} else {
+			err = c.removeOldImage(ctx, "")
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// List returns the existing backups in this region and each copy target,
+// newest first within each.
+func (s *Client) List(ctx context.Context) ([]Backup, error) {
+	var (
+		result = []Backup{}
+		errs   MultiError
+	)
+	for _, c := range append([]*Client{s}, s.copies...) {
+		var (
+			backups []backup
+			kept    map[string]string
+			err     error
+		)
+		if c.takesSnapshotSets() {
+			backups, kept, err = c.snapshotSetCandidates(ctx)
+		} else {
+			backups, kept, err = c.pruneCandidates(ctx)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, b := range backups {
+			reason, ok := kept[b.id]
+			item := Backup{
+				Region:  c.region,
+				Account: c.account,
+				Created: b.created,
+				Keep:    ok,
+				Reason:  reason,
+			}
+			if b.image != nil {
+				item.ImageID = b.id
+				item.Name = aws.StringValue(b.image.Name)
+				item.SnapshotIDs = snapshotIDs(b.image)
+				item.State = aws.StringValue(b.image.State)
+				item.SizeGiB = imageSize(b.image)
+			} else {
+				item.Name = b.id
+				item.State = setState(b.snapshots)
+				for _, snapshot := range b.snapshots {
+					item.SnapshotIDs = append(item.SnapshotIDs, *snapshot.SnapshotId)
+					item.SizeGiB += aws.Int64Value(snapshot.VolumeSize)
+				}
+			}
+			result = append(result, item)
+		}
+	}
+	return result, errs.errorOrNil()
+}
+
+// takesSnapshotSets reports whether backups are snapshot sets rather than
+// images.
+func (s *Client) takesSnapshotSets() bool {
+	return s.mode == ModeSnapshots || s.mode == ModeVolume
+}
+
+// nameBackup names a new backup on this client and its copy targets.
+func (s *Client) nameBackup() {
+	s.imageName = createNameWithTimestamp(s.imageNameWithoutTimestamp)
+	s.newImageID = ""
+	for _, c := range s.copies {
+		c.imageName = s.imageName
+		c.newImageID = ""
+	}
+}
+
+// createImageInput names a new backup of instanceID taken at now, on this
+// client and its copy targets, and returns the request that creates it.
+func (s *Client) createImageInput(
+	instanceID string,
+	now time.Time,
+) *ec2.CreateImageInput {
+	s.nameBackup()
+	return &ec2.CreateImageInput{
+		Name:        aws.String(s.imageName),
+		InstanceId:  aws.String(instanceID),
+		Description: aws.String("This is a test"),
+		NoReboot:    aws.Bool(s.noReboot),
+		DryRun:      aws.Bool(false),
+		TagSpecifications: tagSpecifications(
+			s.backupTags(instanceID, now),
+		),
+	}
+}
@@ -0,0 +1,282 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestNew(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var failing = []Options{
+		{},
+		{ImageName: "testing1.bak", TimeToSave: 604800, KeepLast: -1},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Schedule: Schedule{Weekly: -1}},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Tags: map[string]string{"aws:foo": "bar"}},
+		{ImageName: "testing1.bak", TimeToSave: 604800, ShareAccounts: []string{"1234"}},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Retry: RetryPolicy{MaxAttempts: -1}},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Mode: "ami"},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Mode: ModeVolume, ExcludeBootVolume: true},
+		{ImageName: "testing1.bak", TimeToSave: 604800, ExcludeBootVolume: true},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Mode: ModeSnapshots, NoReboot: true},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Hooks: Hooks{Post: Hook{Timeout: -time.Second}}},
+		{
+			ImageName:     "testing1.bak",
+			TimeToSave:    604800,
+			Mode:          ModeSnapshots,
+			ShareAccounts: []string{"111111111111"},
+		},
+		{ImageName: "testing1.bak", TimeToSave: 604800, Copies: []CopyTarget{{Region: "us-west-2"}}},
+		{
+			ImageName:  "testing1.bak",
+			TimeToSave: 604800,
+			Copies: []CopyTarget{
+				{Client: mockEC2iface, Region: "us-west-2", Account: "vault"},
+			},
+		},
+		// a policy that keeps nothing would prune the new backup too
+		{ImageName: "testing1.bak"},
+		{
+			ImageName:  "testing1.bak",
+			TimeToSave: 604800,
+			Copies: []CopyTarget{
+				{Client: mockEC2iface, Region: "us-west-2", Account: "210987654321"},
+			},
+		},
+	}
+	for _, opts := range failing {
+		if _, err := New(mockEC2iface, opts); err == nil {
+			t.Errorf("Expected an error for %+v but got nil", opts)
+		}
+	}
+
+	s, err := New(mockEC2iface, Options{
+		Region:        "us-east-1",
+		ImageName:     "testing1.bak",
+		TimeToSave:    604800,
+		KeepLast:      2,
+		ShareAccounts: []string{"111111111111"},
+		Copies: []CopyTarget{
+			{Client: mockEC2iface, Region: "us-west-2", KmsKeyID: "alias/backup"},
+			{
+				Client:     mockEC2iface,
+				Region:     "us-east-1",
+				Account:    "210987654321",
+				TimeToSave: 2592000,
+				Schedule:   Schedule{Monthly: 12},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	if s.waitTimeout != DefaultWaitTimeout {
+		t.Errorf("Expected %s got %s", DefaultWaitTimeout, s.waitTimeout)
+	}
+	if s.schedule != nil {
+		t.Errorf("Expected no schedule but got %+v", s.schedule)
+	}
+	var expectShare = []string{"111111111111", "210987654321"}
+	if !reflect.DeepEqual(s.shareAccounts, expectShare) {
+		t.Errorf("Expected %v got %v", expectShare, s.shareAccounts)
+	}
+	if len(s.copies) != 2 {
+		t.Fatalf("Expected 2 copies got %d", len(s.copies))
+	}
+	regional, vault := s.copies[0], s.copies[1]
+	if regional.region != "us-west-2" || regional.kmsKeyID != "alias/backup" ||
+		regional.keepLast != 2 || len(regional.shareAccounts) != 1 ||
+		regional.copies != nil {
+		t.Errorf("Regional copy did not inherit the source's settings: %+v", regional)
+	}
+	if vault.account != "210987654321" || vault.timeToSave != 2592000 ||
+		vault.keepLast != 0 || vault.schedule != (Schedule{Monthly: 12}) ||
+		vault.shareAccounts != nil {
+		t.Errorf("Vault copy did not get its own settings: %+v", vault)
+	}
+}
+
+func TestList(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var (
+		recent = time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+		old    = time.Now().Add(-30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	)
+
+	s, err := New(mockEC2iface, Options{
+		Region:     "us-east-1",
+		ImageName:  "testing1.bak",
+		TimeToSave: 604800,
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					State:        aws.String(ec2.ImageStateAvailable),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: aws.String(old.Format(time.RFC3339)),
+					BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+						{
+							DeviceName: aws.String("/dev/xvda"),
+							Ebs: &ec2.EbsBlockDevice{
+								SnapshotId: aws.String("snap-1"),
+								VolumeSize: aws.Int64(8),
+							},
+						},
+						{
+							DeviceName: aws.String("/dev/sdf"),
+							Ebs: &ec2.EbsBlockDevice{
+								SnapshotId: aws.String("snap-2"),
+								VolumeSize: aws.Int64(100),
+							},
+						},
+					},
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					State:        aws.String(ec2.ImageStatePending),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: aws.String(recent.Format(time.RFC3339)),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing2.bak.438208309884"),
+					Tags:         getBackupTags("testing2.bak"),
+					CreationDate: aws.String(recent.Format(time.RFC3339)),
+				},
+			},
+		},
+		nil,
+	)
+
+	var expect = []Backup{
+		{
+			ImageID:     "ami-123456b",
+			Name:        "testing1.bak.438208309884",
+			SnapshotIDs: []string{},
+			Region:      "us-east-1",
+			Created:     recent,
+			State:       ec2.ImageStatePending,
+			Keep:        true,
+			Reason:      "younger than time-to-save of 604800s",
+		},
+		{
+			ImageID:     "ami-123456a",
+			Name:        "testing1.bak.848590424",
+			SnapshotIDs: []string{"snap-1", "snap-2"},
+			Region:      "us-east-1",
+			Created:     old,
+			State:       ec2.ImageStateAvailable,
+			SizeGiB:     108,
+		},
+	}
+	result, err := s.List(context.Background())
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %+v got %+v", expect, result)
+	}
+
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := s.List(context.Background()); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	s, err := New(mockEC2iface, Options{
+		Region:     "us-east-1",
+		ImageName:  "testing1.bak",
+		TimeToSave: 604800,
+		Copies: []CopyTarget{
+			{Client: mockEC2iface, Region: "us-west-2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+
+	// the source region fails to list, the copy is still pruned
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: aws.String("2015-06-07T00:00:00Z"),
+					BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+						{
+							DeviceName: aws.String("/dev/xvda"),
+							Ebs: &ec2.EbsBlockDevice{
+								SnapshotId: aws.String("snap-1"),
+							},
+						},
+					},
+				},
+			},
+		},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+		gomock.Any(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1"),
+			DryRun:     aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeleteSnapshotOutput{},
+		nil,
+	)
+
+	err = s.Prune(context.Background())
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 {
+		t.Errorf("Expected 1 error but got %v", err)
+	}
+}
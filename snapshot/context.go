@@ -0,0 +1,26 @@
+package snapshot
+
+import (
+	"context"
+	"time"
+)
+
+// cleanupTimeout bounds work that must finish even after the run's context
+// is done, such as deleting the snapshots of an image that has already been
+// deregistered.
+const cleanupTimeout = 5 * time.Minute
+
+// detachedContext carries the values of its parent but never its
+// cancellation or deadline.
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// withoutCancel returns a context for finishing a step that was started
+// under ctx.  It is not cancelled along with ctx, but gives up after
+// cleanupTimeout so a hung call still cannot block forever.
+func withoutCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(detachedContext{ctx}, cleanupTimeout)
+}
@@ -0,0 +1,154 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestRemoveOldImageCancelled(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+	}
+
+	var oldImage = func(id, snapshotID string) *ec2.Image {
+		return &ec2.Image{
+			ImageId:      aws.String(id),
+			Name:         aws.String("testing1.bak." + id),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: aws.String("2015-06-07T00:00:00Z"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs: &ec2.EbsBlockDevice{
+						SnapshotId: aws.String(snapshotID),
+					},
+				},
+			},
+		}
+	}
+
+	// already cancelled: nothing is deleted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{oldImage("ami-123456a", "snap-1")},
+		},
+		nil,
+	)
+	if err := s.removeOldImage(ctx, ""); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+
+	// cancelled while deregistering: that image's snapshots are still
+	// deleted, the next image is left alone
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				oldImage("ami-123456a", "snap-1"),
+				oldImage("ami-123456b", "snap-2"),
+			},
+		},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		},
+	).Do(
+		func(aws.Context, *ec2.DeregisterImageInput, ...request.Option) {
+			cancel()
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+		gomock.Any(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1"),
+			DryRun:     aws.Bool(false),
+		},
+	).Do(
+		func(ctx aws.Context, _ *ec2.DeleteSnapshotInput, _ ...request.Option) {
+			if ctx.Err() != nil {
+				t.Errorf("Expected a live context but got %v", ctx.Err())
+			}
+		},
+	).Return(
+		&ec2.DeleteSnapshotOutput{},
+		nil,
+	)
+	err := s.removeOldImage(ctx, "")
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 {
+		t.Errorf("Expected 1 error but got %v", err)
+	}
+}
+
+func TestWaitForImageCancelled(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:          mockEC2iface,
+		imageName:    "testing1.bak.1257894000",
+		waitTimeout:  time.Hour,
+		pollInterval: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockEC2iface.EXPECT().DescribeImagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-123456a")},
+		},
+	).Do(
+		func(aws.Context, *ec2.DescribeImagesInput, ...request.Option) {
+			cancel()
+		},
+	).Return(
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId: aws.String("ami-123456a"),
+					State:   aws.String(ec2.ImageStatePending),
+				},
+			},
+		},
+		nil,
+	)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.waitForImage(ctx, "ami-123456a")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error but got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForImage did not stop when its context was cancelled")
+	}
+}
@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// CopyTarget is a destination every new backup is copied to, with a client
+// for the destination region.  Targets in another account set Account and
+// bring their own retention settings and filters; other targets share the
+// source's.
+type CopyTarget struct {
+	Client     ec2iface.EC2API
+	Region     string
+	KmsKeyID   string
+	Account    string
+	TimeToSave int64
+	KeepLast   int
+	Schedule   Schedule
+	Filters    []*ec2.Filter
+}
+
+// copyImage copies image from sourceRegion into this region, optionally
+// re-encrypting it with kmsKeyID, and waits for the copy to become
+// available.  The copy and its snapshots get the source image's tags, since
+// CopyImage does not carry them over, and old copies in this region are
+// then pruned under the same retention policy as the source.
+func (s *Client) copyImage(
+	ctx context.Context,
+	sourceRegion string,
+	image *ec2.Image,
+) (string, error) {
+	var input = &ec2.CopyImageInput{
+		Name:          image.Name,
+		Description:   image.Description,
+		SourceImageId: image.ImageId,
+		SourceRegion:  aws.String(sourceRegion),
+		DryRun:        aws.Bool(false),
+	}
+	if s.kmsKeyID != "" {
+		input.Encrypted = aws.Bool(true)
+		input.KmsKeyId = aws.String(s.kmsKeyID)
+	}
+	outputData, err := s.svc.CopyImageWithContext(ctx, input)
+	if err != nil {
+		return "", &CreateError{
+			s.imageName,
+			fmt.Sprintf(
+				"Failed to copy image %s to %s b/c of %s",
+				*image.ImageId,
+				s.region,
+				err.Error(),
+			),
+		}
+	}
+	s.newImageID = *outputData.ImageId
+	copied, err := s.waitForImage(ctx, *outputData.ImageId)
+	if err != nil {
+		return "", err
+	}
+	if len(image.Tags) > 0 {
+		_, err = s.svc.CreateTagsWithContext(
+			ctx,
+			&ec2.CreateTagsInput{
+				Resources: aws.StringSlice(
+					append([]string{*copied.ImageId}, snapshotIDs(copied)...),
+				),
+				Tags:   image.Tags,
+				DryRun: aws.Bool(false),
+			},
+		)
+		if err != nil {
+			return "", &CreateError{
+				s.imageName,
+				fmt.Sprintf(
+					"Failed to tag copy %s in %s b/c of %s",
+					*copied.ImageId,
+					s.region,
+					err.Error(),
+				),
+			}
+		}
+	}
+	if err := s.shareImage(ctx, copied); err != nil {
+		return "", &CreateError{s.imageName, err.Error()}
+	}
+	if err := s.removeOldImage(ctx, *copied.ImageId); err != nil {
+		return "", err
+	}
+	return *copied.ImageId, nil
+}
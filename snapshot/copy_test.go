@@ -0,0 +1,142 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestCopyImage(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var source = &ec2.Image{
+		ImageId:     aws.String("ami-123456a"),
+		Name:        aws.String("testing1.bak.20160102030405"),
+		Description: aws.String("nightly"),
+		Tags:        getBackupTags("testing1.bak"),
+	}
+
+	var tests = []struct {
+		kmsKeyID string
+		input    *ec2.CopyImageInput
+	}{
+		{
+			kmsKeyID: "",
+			input: &ec2.CopyImageInput{
+				Name:          aws.String("testing1.bak.20160102030405"),
+				Description:   aws.String("nightly"),
+				SourceImageId: aws.String("ami-123456a"),
+				SourceRegion:  aws.String("us-east-1"),
+				DryRun:        aws.Bool(false),
+			},
+		},
+		{
+			kmsKeyID: "alias/backup",
+			input: &ec2.CopyImageInput{
+				Name:          aws.String("testing1.bak.20160102030405"),
+				Description:   aws.String("nightly"),
+				SourceImageId: aws.String("ami-123456a"),
+				SourceRegion:  aws.String("us-east-1"),
+				DryRun:        aws.Bool(false),
+				Encrypted:     aws.Bool(true),
+				KmsKeyId:      aws.String("alias/backup"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s := &Client{
+			svc:                       mockEC2iface,
+			region:                    "us-west-2",
+			imageNameWithoutTimestamp: "testing1.bak",
+			imageName:                 "testing1.bak.20160102030405",
+			timeToSave:                604800,
+			filter:                    filters,
+			waitTimeout:               time.Second,
+			pollInterval:              time.Millisecond,
+			kmsKeyID:                  test.kmsKeyID,
+		}
+		mockEC2iface.EXPECT().CopyImageWithContext(gomock.Any(), test.input).Return(
+			&ec2.CopyImageOutput{ImageId: aws.String("ami-654321a")},
+			nil,
+		)
+		mockEC2iface.EXPECT().DescribeImagesWithContext(
+			gomock.Any(),
+			&ec2.DescribeImagesInput{
+				ImageIds: []*string{aws.String("ami-654321a")},
+			},
+		).Return(
+			&ec2.DescribeImagesOutput{
+				Images: []*ec2.Image{
+					{
+						ImageId: aws.String("ami-654321a"),
+						State:   aws.String(ec2.ImageStateAvailable),
+						BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+							{
+								DeviceName: aws.String("/dev/xvda"),
+								Ebs: &ec2.EbsBlockDevice{
+									SnapshotId: aws.String("snap-2"),
+								},
+							},
+						},
+					},
+				},
+			},
+			nil,
+		)
+		mockEC2iface.EXPECT().CreateTagsWithContext(
+			gomock.Any(),
+			&ec2.CreateTagsInput{
+				Resources: aws.StringSlice([]string{"ami-654321a", "snap-2"}),
+				Tags:      source.Tags,
+				DryRun:    aws.Bool(false),
+			},
+		).Return(
+			&ec2.CreateTagsOutput{},
+			nil,
+		)
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			&ec2.DescribeImagesOutput{},
+			nil,
+		)
+		id, err := s.copyImage(context.Background(), "us-east-1", source)
+		if err != nil {
+			t.Errorf("Expected nil but got %v", err)
+		}
+		if id != "ami-654321a" {
+			t.Errorf("Expected ami-654321a got %s", id)
+		}
+	}
+
+	s := &Client{
+		svc:                       mockEC2iface,
+		region:                    "us-west-2",
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.20160102030405",
+		filter:                    filters,
+	}
+	mockEC2iface.EXPECT().CopyImageWithContext(gomock.Any(), tests[0].input).Return(
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := s.copyImage(context.Background(), "us-east-1", source); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DefaultHookTimeout is how long a hook may run when its Timeout is not
+// set.
+const DefaultHookTimeout = 5 * time.Minute
+
+// Environment variables a hook is run with, on top of this process's own.
+// Only one of the instance and volume IDs is set, depending on the mode.
+const (
+	HookEnvInstanceID = "EC2_SNAPSHOT_INSTANCE_ID"
+	HookEnvVolumeID   = "EC2_SNAPSHOT_VOLUME_ID"
+	HookEnvImageName  = "EC2_SNAPSHOT_IMAGE_NAME"
+	HookEnvRegion     = "EC2_SNAPSHOT_REGION"
+)
+
+// Hook is a command run locally through /bin/sh, for example to freeze a
+// filesystem or flush a database over ssh.  Its output goes to this
+// process's stdout and stderr.  An empty Command does nothing.
+type Hook struct {
+	Command string
+	Timeout time.Duration
+}
+
+// Hooks are run around the call that starts a backup: Pre just before
+// CreateImage, CreateSnapshots or CreateSnapshot and Post as soon as it
+// returns, before waiting for the backup to complete.  A failed Pre hook
+// fails the backup without taking it.  Post always runs once Pre has been
+// tried, even if Pre or the backup failed or the run was cancelled, so
+// whatever Pre froze is thawed.
+type Hooks struct {
+	Pre  Hook
+	Post Hook
+}
+
+// Validate reports whether h has a negative timeout.
+func (h Hooks) Validate() error {
+	if h.Pre.Timeout < 0 || h.Post.Timeout < 0 {
+		return fmt.Errorf("Hook timeouts must not be negative")
+	}
+	return nil
+}
+
+// run runs h with env and waits for it to exit, killing it if it outlives
+// its timeout or ctx.  Only the shell is killed, so a hook that starts
+// long running children should exec or time them out itself.
+func (h Hook) run(ctx context.Context, name string, env []string) error {
+	if h.Command == "" {
+		return nil
+	}
+	var timeout = h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.Command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s hook was stopped b/c of %s", name, ctx.Err().Error())
+		}
+		return fmt.Errorf("%s hook failed b/c of %s", name, err.Error())
+	}
+	return nil
+}
+
+// quiesce calls create between the pre and post hooks for targetID, an
+// instance or volume ID.  It returns the error of the pre hook or create,
+// together with that of the post hook in a MultiError; callers tell a
+// failed post hook apart by whether create produced a backup.
+func (s *Client) quiesce(
+	ctx context.Context,
+	targetID string,
+	create func() error,
+) error {
+	var (
+		env  = s.hookEnv(targetID)
+		errs MultiError
+	)
+	err := s.hooks.Pre.run(ctx, "Pre", env)
+	if err == nil {
+		err = create()
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	postCtx, cancel := withoutCancel(ctx)
+	defer cancel()
+	if err := s.hooks.Post.run(postCtx, "Post", env); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.errorOrNil()
+}
+
+// hookEnv returns the environment that tells a hook what is being backed
+// up.
+func (s *Client) hookEnv(targetID string) []string {
+	var idKey = HookEnvInstanceID
+	if s.mode == ModeVolume {
+		idKey = HookEnvVolumeID
+	}
+	return []string{
+		idKey + "=" + targetID,
+		HookEnvImageName + "=" + s.imageName,
+		HookEnvRegion + "=" + s.region,
+	}
+}
@@ -0,0 +1,154 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestHookRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var out = filepath.Join(dir, "env")
+
+	var tests = []struct {
+		hook      Hook
+		expectErr bool
+	}{
+		{Hook{}, false},
+		{Hook{Command: "true"}, false},
+		{Hook{Command: "exit 3"}, true},
+		{Hook{Command: "exec sleep 5", Timeout: 50 * time.Millisecond}, true},
+		{
+			Hook{
+				Command: `echo "$` + HookEnvInstanceID + ` $` + HookEnvImageName + `" > ` + out,
+			},
+			false,
+		},
+	}
+	for _, test := range tests {
+		err := test.hook.run(
+			context.Background(),
+			"Pre",
+			[]string{
+				HookEnvInstanceID + "=i-1234abc",
+				HookEnvImageName + "=testing1.bak.1257894000",
+			},
+		)
+		if test.expectErr && err == nil {
+			t.Errorf("Expected an error for %q but got nil", test.hook.Command)
+		}
+		if !test.expectErr && err != nil {
+			t.Errorf("Expected nil for %q but got %v", test.hook.Command, err)
+		}
+	}
+	dump, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Expected the hook to write %s but got %v", out, err)
+	}
+	if result := strings.TrimSpace(string(dump)); result != "i-1234abc testing1.bak.1257894000" {
+		t.Errorf("Expected the instance ID and image name got %q", result)
+	}
+}
+
+func TestQuiesce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		pre  = filepath.Join(dir, "pre")
+		post = filepath.Join(dir, "post")
+	)
+
+	var tests = []struct {
+		pre         string
+		post        string
+		createErr   error
+		expectCalls bool
+		expectErr   bool
+	}{
+		{"touch " + pre, "touch " + post, nil, true, false},
+		{"exit 1", "touch " + post, nil, false, true},
+		{"touch " + pre, "touch " + post, errors.New("Some error blah blah"), true, true},
+		{"touch " + pre, "exit 1", nil, true, true},
+	}
+	for i, test := range tests {
+		os.Remove(pre)
+		os.Remove(post)
+		var (
+			s = &Client{
+				imageName: "testing1.bak.1257894000",
+				hooks: Hooks{
+					Pre:  Hook{Command: test.pre},
+					Post: Hook{Command: test.post},
+				},
+			}
+			called bool
+		)
+		err := s.quiesce(context.Background(), "i-1234abc", func() error {
+			if _, err := os.Stat(pre); err != nil {
+				t.Errorf("Test %d: expected the pre hook to run before create", i)
+			}
+			if _, err := os.Stat(post); err == nil {
+				t.Errorf("Test %d: expected the post hook to run after create", i)
+			}
+			called = true
+			return test.createErr
+		})
+		if called != test.expectCalls {
+			t.Errorf("Test %d: expected create called to be %t", i, test.expectCalls)
+		}
+		if test.expectErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t but got %v", i, test.expectErr, err)
+		}
+		if _, err := os.Stat(post); err != nil && test.post != "exit 1" {
+			t.Errorf("Test %d: expected the post hook to run", i)
+		}
+	}
+
+	// a cancelled run still thaws
+	os.Remove(post)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var s = &Client{hooks: Hooks{Post: Hook{Command: "touch " + post}}}
+	if err := s.quiesce(ctx, "i-1234abc", func() error { return ctx.Err() }); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+	if _, err := os.Stat(post); err != nil {
+		t.Error("Expected the post hook to run after the run was cancelled")
+	}
+}
+
+func TestCreateImagePreHookFails(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		hooks:                     Hooks{Pre: Hook{Command: "exit 1"}},
+	}
+	// no CreateImage is expected
+	_, err := s.createImage(context.Background(), &ec2.CreateImageInput{
+		Name:       aws.String("testing1.bak.1257894000"),
+		InstanceId: aws.String("i-1234abc"),
+		NoReboot:   aws.Bool(true),
+	})
+	if err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
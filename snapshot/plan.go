@@ -0,0 +1,222 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	ActionCreate = "create"
+	ActionKeep   = "keep"
+	ActionDelete = "delete"
+
+	ResourceImage       = "image"
+	ResourceSnapshot    = "snapshot"
+	ResourceSnapshotSet = "snapshot-set"
+	ResourceInstance    = "instance"
+)
+
+// PlanItem is one change a run would make, or one backup it would leave
+// alone and why.
+type PlanItem struct {
+	Action     string `json:"action"`
+	Resource   string `json:"resource"`
+	ID         string `json:"id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	VolumeID   string `json:"volume_id,omitempty"`
+	Region     string `json:"region,omitempty"`
+	Account    string `json:"account,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// plan walks the same logic as createImage without calling any API that
+// changes state.  The image about to be created is ranked against the
+// existing backups, so keep-last and schedules see what a real run sees.
+// Like createImage it carries on past failures, returning what it could
+// plan together with a MultiError.
+func (s *Client) plan(
+	ctx context.Context,
+	imageMeta *ec2.CreateImageInput,
+) ([]PlanItem, error) {
+	var result = []PlanItem{
+		{
+			Action:     ActionCreate,
+			Resource:   ResourceImage,
+			Name:       *imageMeta.Name,
+			InstanceID: *imageMeta.InstanceId,
+			Region:     s.region,
+		},
+	}
+	var errs MultiError
+	items, err := s.planPrune(ctx, *imageMeta.Name)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	result = append(result, items...)
+	for _, c := range s.copies {
+		result = append(result, PlanItem{
+			Action:   ActionCreate,
+			Resource: ResourceImage,
+			Name:     *imageMeta.Name,
+			Region:   c.region,
+			Account:  c.account,
+			Reason:   "copy from " + s.region,
+		})
+		items, err := c.planPrune(ctx, *imageMeta.Name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		result = append(result, items...)
+	}
+	return result, errs.errorOrNil()
+}
+
+// planPrune lists what removeOldImage would keep and delete once an image
+// named newImageName exists.
+func (s *Client) planPrune(
+	ctx context.Context,
+	newImageName string,
+) ([]PlanItem, error) {
+	var result = []PlanItem{}
+	images, kept, err := s.pruneCandidates(
+		ctx,
+		backup{
+			id: "",
+			image: &ec2.Image{
+				ImageId: aws.String(""),
+				Name:    aws.String(newImageName),
+			},
+			created: time.Now(),
+		},
+	)
+	if images == nil {
+		return nil, err
+	}
+	var errs MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, b := range images {
+		image := b.image
+		if *image.ImageId == "" {
+			continue
+		}
+		if reason, ok := kept[*image.ImageId]; ok {
+			result = append(result, PlanItem{
+				Action:   ActionKeep,
+				Resource: ResourceImage,
+				ID:       *image.ImageId,
+				Name:     *image.Name,
+				Region:   s.region,
+				Account:  s.account,
+				Reason:   reason,
+			})
+			continue
+		}
+		result = append(result, PlanItem{
+			Action:   ActionDelete,
+			Resource: ResourceImage,
+			ID:       *image.ImageId,
+			Name:     *image.Name,
+			Region:   s.region,
+			Account:  s.account,
+		})
+		ids := snapshotIDs(image)
+		if len(ids) == 0 {
+			snapshots, err := s.findSnapshotsByDescription(ctx, *image.ImageId)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, snapshot := range snapshots {
+				ids = append(ids, *snapshot.SnapshotId)
+			}
+		}
+		for _, id := range ids {
+			result = append(result, PlanItem{
+				Action:   ActionDelete,
+				Resource: ResourceSnapshot,
+				ID:       id,
+				Region:   s.region,
+				Account:  s.account,
+				Reason:   "belongs to " + *image.ImageId,
+			})
+		}
+	}
+	return result, errs.errorOrNil()
+}
+
+// planSnapshotSet is plan for ModeSnapshots and ModeVolume, where sourceID
+// is an instance or a volume ID.  The set about to be taken is ranked
+// against the existing sets like plan ranks a new image.
+func (s *Client) planSnapshotSet(
+	ctx context.Context,
+	sourceID string,
+) ([]PlanItem, error) {
+	var create = PlanItem{
+		Action:   ActionCreate,
+		Resource: ResourceSnapshotSet,
+		Name:     s.imageName,
+		Region:   s.region,
+	}
+	if s.mode == ModeVolume {
+		create.VolumeID = sourceID
+	} else {
+		create.InstanceID = sourceID
+	}
+	var result = []PlanItem{create}
+	sets, kept, err := s.snapshotSetCandidates(
+		ctx,
+		backup{id: "", created: time.Now()},
+	)
+	if sets == nil {
+		return result, err
+	}
+	for _, b := range sets {
+		if b.id == "" {
+			continue
+		}
+		if reason, ok := kept[b.id]; ok {
+			result = append(result, PlanItem{
+				Action:   ActionKeep,
+				Resource: ResourceSnapshotSet,
+				Name:     b.id,
+				Region:   s.region,
+				Reason:   reason,
+			})
+			continue
+		}
+		result = append(result, PlanItem{
+			Action:   ActionDelete,
+			Resource: ResourceSnapshotSet,
+			Name:     b.id,
+			Region:   s.region,
+		})
+		for _, snapshot := range b.snapshots {
+			result = append(result, PlanItem{
+				Action:   ActionDelete,
+				Resource: ResourceSnapshot,
+				ID:       *snapshot.SnapshotId,
+				Region:   s.region,
+				Reason:   "belongs to " + b.id,
+			})
+		}
+	}
+	return result, err
+}
+
+// WritePlan writes items to w as indented JSON.
+func WritePlan(w io.Writer, items []PlanItem) error {
+	dump, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(dump, '\n'))
+	return err
+}
@@ -0,0 +1,219 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestPlan(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var getTimeSecondsBeforeNowAsString = func(s int64) *string {
+		t := time.Now().Add(-time.Duration(s) * time.Second).Format(time.RFC3339)
+		return &t
+	}
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var awsImages = []*ec2.Image{
+		{
+			ImageId:      aws.String("ami-123456a"),
+			Name:         aws.String("testing1.bak.848590424"),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: getTimeSecondsBeforeNowAsString(100),
+		},
+		{
+			ImageId:      aws.String("ami-123456b"),
+			Name:         aws.String("testing1.bak.438208309884"),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: getTimeSecondsBeforeNowAsString(1000000),
+		},
+		{
+			ImageId:      aws.String("ami-123456c"),
+			Name:         aws.String("testing1.bak.4284932088"),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: getTimeSecondsBeforeNowAsString(2000000),
+		},
+	}
+
+	var awsSnapshots = []*ec2.Snapshot{
+		{
+			SnapshotId:  aws.String("snap-1"),
+			Description: aws.String("This snapshot is taken from ami-123456c"),
+		},
+	}
+
+	var tests = []struct {
+		keepLast int
+		expect   []PlanItem
+	}{
+		{
+			keepLast: 0,
+			expect: []PlanItem{
+				{
+					Action:     ActionCreate,
+					Resource:   ResourceImage,
+					Name:       "testing1.bak.1257894000",
+					InstanceID: "i-1234abc",
+				},
+				{
+					Action:   ActionKeep,
+					Resource: ResourceImage,
+					ID:       "ami-123456a",
+					Name:     "testing1.bak.848590424",
+					Reason:   "younger than time-to-save of 604800s",
+				},
+				{
+					Action:   ActionDelete,
+					Resource: ResourceImage,
+					ID:       "ami-123456b",
+					Name:     "testing1.bak.438208309884",
+				},
+				{
+					Action:   ActionDelete,
+					Resource: ResourceImage,
+					ID:       "ami-123456c",
+					Name:     "testing1.bak.4284932088",
+				},
+				{
+					Action:   ActionDelete,
+					Resource: ResourceSnapshot,
+					ID:       "snap-1",
+					Reason:   "belongs to ami-123456c",
+				},
+			},
+		},
+		{
+			// the image about to be created counts as the newest backup
+			keepLast: 3,
+			expect: []PlanItem{
+				{
+					Action:     ActionCreate,
+					Resource:   ResourceImage,
+					Name:       "testing1.bak.1257894000",
+					InstanceID: "i-1234abc",
+				},
+				{
+					Action:   ActionKeep,
+					Resource: ResourceImage,
+					ID:       "ami-123456a",
+					Name:     "testing1.bak.848590424",
+					Reason:   "younger than time-to-save of 604800s",
+				},
+				{
+					Action:   ActionKeep,
+					Resource: ResourceImage,
+					ID:       "ami-123456b",
+					Name:     "testing1.bak.438208309884",
+					Reason:   "one of the 3 newest",
+				},
+				{
+					Action:   ActionDelete,
+					Resource: ResourceImage,
+					ID:       "ami-123456c",
+					Name:     "testing1.bak.4284932088",
+				},
+				{
+					Action:   ActionDelete,
+					Resource: ResourceSnapshot,
+					ID:       "snap-1",
+					Reason:   "belongs to ami-123456c",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s := &Client{
+			svc:                       mockEC2iface,
+			imageNameWithoutTimestamp: "testing1.bak",
+			imageName:                 "testing1.bak.1257894000",
+			timeToSave:                604800,
+			keepLast:                  test.keepLast,
+			filter:                    filters,
+		}
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			&ec2.DescribeImagesOutput{Images: awsImages},
+			nil,
+		)
+		expectSnapshotPages(
+			mockEC2iface,
+			gomock.Any(),
+			&ec2.DescribeSnapshotsOutput{Snapshots: awsSnapshots},
+			nil,
+		).AnyTimes()
+		result, err := s.plan(context.Background(), &ec2.CreateImageInput{
+			Name:       aws.String(s.imageName),
+			InstanceId: aws.String("i-1234abc"),
+		})
+		if err != nil {
+			t.Errorf("Expected nil but got %v", err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %+v got %+v", test.expect, result)
+		}
+	}
+
+	s := &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+		filter:                    filters,
+	}
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(filters, "testing1.bak"),
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := s.plan(context.Background(), &ec2.CreateImageInput{
+		Name:       aws.String(s.imageName),
+		InstanceId: aws.String("i-1234abc"),
+	}); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	var buf bytes.Buffer
+	err := WritePlan(&buf, []PlanItem{
+		{
+			Action:   ActionDelete,
+			Resource: ResourceImage,
+			ID:       "ami-123456a",
+		},
+	})
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	expect := `[
+  {
+    "action": "delete",
+    "resource": "image",
+    "id": "ami-123456a"
+  }
+]
+`
+	if buf.String() != expect {
+		t.Errorf("Expected %s got %s", expect, buf.String())
+	}
+}
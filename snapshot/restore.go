@@ -0,0 +1,194 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// RestoreOptions describes the instance Restore launches from the backup
+// image ImageID.  InstanceType, SubnetID, KeyName and SecurityGroupIDs are
+// copied from the instance the image is a backup of when left empty, as
+// long as that instance can still be described.  Name defaults to the
+// image's name.
+type RestoreOptions struct {
+	ImageID          string
+	Name             string
+	InstanceType     string
+	SubnetID         string
+	KeyName          string
+	SecurityGroupIDs []string
+}
+
+// FindImage returns the image imageID as seen by DescribeImages.
+func FindImage(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	imageID string,
+) (*ec2.Image, error) {
+	resp, err := svc.DescribeImagesWithContext(
+		ctx,
+		&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageID)}},
+	)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf(
+			"Failed to describe image %s b/c of %s",
+			imageID,
+			err.Error(),
+		)
+	}
+	if err != nil || len(resp.Images) == 0 {
+		return nil, fmt.Errorf("Image %s was not found", imageID)
+	}
+	return resp.Images[0], nil
+}
+
+// RestoreInput returns the request that launches one instance from the
+// image opts names, with the gaps in opts filled in from its source
+// instance.  It only describes resources, so it also serves dry runs.
+func RestoreInput(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	opts RestoreOptions,
+) (*ec2.RunInstancesInput, error) {
+	return restoreInput(ctx, svc, opts, time.Now())
+}
+
+func restoreInput(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	opts RestoreOptions,
+	now time.Time,
+) (*ec2.RunInstancesInput, error) {
+	image, err := FindImage(ctx, svc, opts.ImageID)
+	if err != nil {
+		return nil, err
+	}
+	if state := aws.StringValue(image.State); state != ec2.ImageStateAvailable {
+		return nil, fmt.Errorf("Image %s is %s, not available", opts.ImageID, state)
+	}
+	source, err := restoreSource(ctx, svc, image)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		if opts.InstanceType == "" {
+			opts.InstanceType = aws.StringValue(source.InstanceType)
+		}
+		if opts.SubnetID == "" {
+			opts.SubnetID = aws.StringValue(source.SubnetId)
+		}
+		if opts.KeyName == "" {
+			opts.KeyName = aws.StringValue(source.KeyName)
+		}
+		if len(opts.SecurityGroupIDs) == 0 {
+			for _, group := range source.SecurityGroups {
+				opts.SecurityGroupIDs = append(opts.SecurityGroupIDs, aws.StringValue(group.GroupId))
+			}
+		}
+	}
+	if opts.InstanceType == "" {
+		return nil, fmt.Errorf(
+			"An instance type is needed to restore %s, which has no source instance to copy it from",
+			opts.ImageID,
+		)
+	}
+	if opts.Name == "" {
+		opts.Name = aws.StringValue(image.Name)
+	}
+
+	input := &ec2.RunInstancesInput{
+		// lets a retry find the instance the first attempt launched
+		ClientToken:  aws.String(fmt.Sprintf("%s-%d", opts.ImageID, now.UnixNano())),
+		ImageId:      aws.String(opts.ImageID),
+		InstanceType: aws.String(opts.InstanceType),
+		MinCount:     aws.Int64(1),
+		MaxCount:     aws.Int64(1),
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeInstance),
+				Tags: toEC2Tags(map[string]string{
+					"Name":          opts.Name,
+					tagRestoredFrom: opts.ImageID,
+				}),
+			},
+		},
+	}
+	if opts.SubnetID != "" {
+		input.SubnetId = aws.String(opts.SubnetID)
+	}
+	if opts.KeyName != "" {
+		input.KeyName = aws.String(opts.KeyName)
+	}
+	if len(opts.SecurityGroupIDs) > 0 {
+		input.SecurityGroupIds = aws.StringSlice(opts.SecurityGroupIDs)
+	}
+	return input, nil
+}
+
+// restoreSource returns the instance image is a backup of, or nil when it
+// was not tagged with one or that instance is gone.
+func restoreSource(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	image *ec2.Image,
+) (*ec2.Instance, error) {
+	instanceID := getTagValue(image.Tags, tagSourceInstance)
+	if instanceID == "" {
+		return nil, nil
+	}
+	var result *ec2.Instance
+	err := svc.DescribeInstancesPagesWithContext(
+		ctx,
+		&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{aws.String(instanceID)},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					result = instance
+					return false
+				}
+			}
+			return true
+		},
+	)
+	if hasCode(err, "InvalidInstanceID.NotFound") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to describe source instance %s b/c of %s",
+			instanceID,
+			err.Error(),
+		)
+	}
+	return result, nil
+}
+
+// Restore launches the instance input describes and returns its ID.
+func Restore(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	input *ec2.RunInstancesInput,
+) (string, error) {
+	resp, err := svc.RunInstancesWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf(
+			"Failed to launch an instance from %s b/c of %s",
+			aws.StringValue(input.ImageId),
+			err.Error(),
+		)
+	}
+	if len(resp.Instances) == 0 {
+		return "", fmt.Errorf(
+			"Launching an instance from %s returned no instance",
+			aws.StringValue(input.ImageId),
+		)
+	}
+	return aws.StringValue(resp.Instances[0].InstanceId), nil
+}
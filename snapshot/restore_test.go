@@ -0,0 +1,237 @@
+package snapshot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestRestoreInput(t *testing.T) {
+	var (
+		now    = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.UTC)
+		source = &ec2.Instance{
+			InstanceId:   aws.String("i-1234abc"),
+			InstanceType: aws.String("m4.large"),
+			SubnetId:     aws.String("subnet-1"),
+			KeyName:      aws.String("ops"),
+			SecurityGroups: []*ec2.GroupIdentifier{
+				{GroupId: aws.String("sg-1")},
+				{GroupId: aws.String("sg-2")},
+			},
+		}
+		image = &ec2.Image{
+			ImageId: aws.String("ami-123456a"),
+			Name:    aws.String("web01.backup-20160315120000"),
+			State:   aws.String(ec2.ImageStateAvailable),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(tagSourceInstance), Value: aws.String("i-1234abc")},
+			},
+		}
+		pending = &ec2.Image{
+			ImageId: aws.String("ami-123456a"),
+			State:   aws.String(ec2.ImageStatePending),
+		}
+	)
+
+	expectInput := func(
+		instanceType string,
+		subnet string,
+		key string,
+		groups []string,
+		name string,
+	) *ec2.RunInstancesInput {
+		input := &ec2.RunInstancesInput{
+			ClientToken:  aws.String("ami-123456a-1458043200000000000"),
+			ImageId:      aws.String("ami-123456a"),
+			InstanceType: aws.String(instanceType),
+			MinCount:     aws.Int64(1),
+			MaxCount:     aws.Int64(1),
+			TagSpecifications: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Tags: []*ec2.Tag{
+						{Key: aws.String("Name"), Value: aws.String(name)},
+						{Key: aws.String(tagRestoredFrom), Value: aws.String("ami-123456a")},
+					},
+				},
+			},
+		}
+		if subnet != "" {
+			input.SubnetId = aws.String(subnet)
+		}
+		if key != "" {
+			input.KeyName = aws.String(key)
+		}
+		if len(groups) > 0 {
+			input.SecurityGroupIds = aws.StringSlice(groups)
+		}
+		return input
+	}
+
+	var tests = []struct {
+		opts      RestoreOptions
+		image     *ec2.Image
+		source    *ec2.Instance
+		sourceErr error
+		expect    *ec2.RunInstancesInput
+		fail      bool
+	}{
+		// everything left out comes from the source instance
+		{
+			opts:   RestoreOptions{ImageID: "ami-123456a"},
+			image:  image,
+			source: source,
+			expect: expectInput(
+				"m4.large",
+				"subnet-1",
+				"ops",
+				[]string{"sg-1", "sg-2"},
+				"web01.backup-20160315120000",
+			),
+		},
+		{
+			opts: RestoreOptions{
+				ImageID:          "ami-123456a",
+				Name:             "web01-restored",
+				InstanceType:     "t2.micro",
+				SecurityGroupIDs: []string{"sg-3"},
+			},
+			image:  image,
+			source: source,
+			expect: expectInput("t2.micro", "subnet-1", "ops", []string{"sg-3"}, "web01-restored"),
+		},
+		// the source is gone, so only what was given is used
+		{
+			opts:      RestoreOptions{ImageID: "ami-123456a", InstanceType: "t2.micro"},
+			image:     image,
+			sourceErr: awserr.New("InvalidInstanceID.NotFound", "not found", nil),
+			expect:    expectInput("t2.micro", "", "", nil, "web01.backup-20160315120000"),
+		},
+		{
+			opts:      RestoreOptions{ImageID: "ami-123456a"},
+			image:     image,
+			sourceErr: awserr.New("InvalidInstanceID.NotFound", "not found", nil),
+			fail:      true,
+		},
+		{
+			opts:      RestoreOptions{ImageID: "ami-123456a"},
+			image:     image,
+			sourceErr: awserr.New("UnauthorizedOperation", "denied", nil),
+			fail:      true,
+		},
+		{
+			opts:  RestoreOptions{ImageID: "ami-123456a", InstanceType: "t2.micro"},
+			image: pending,
+			fail:  true,
+		},
+		{
+			opts: RestoreOptions{ImageID: "ami-123456a", InstanceType: "t2.micro"},
+			fail: true,
+		},
+	}
+	for _, test := range tests {
+		mockEC2iface, ctrl := getMocks(t)
+		var images []*ec2.Image
+		if test.image != nil {
+			images = append(images, test.image)
+		}
+		mockEC2iface.EXPECT().DescribeImagesWithContext(
+			gomock.Any(),
+			&ec2.DescribeImagesInput{ImageIds: aws.StringSlice([]string{"ami-123456a"})},
+		).Return(&ec2.DescribeImagesOutput{Images: images}, nil)
+		if test.source != nil || test.sourceErr != nil {
+			mockEC2iface.EXPECT().DescribeInstancesPagesWithContext(
+				gomock.Any(),
+				&ec2.DescribeInstancesInput{
+					InstanceIds: aws.StringSlice([]string{"i-1234abc"}),
+				},
+				gomock.Any(),
+			).Do(
+				func(
+					_ aws.Context,
+					_ *ec2.DescribeInstancesInput,
+					fn func(*ec2.DescribeInstancesOutput, bool) bool,
+					_ ...request.Option,
+				) {
+					if test.source == nil {
+						return
+					}
+					fn(&ec2.DescribeInstancesOutput{
+						Reservations: []*ec2.Reservation{
+							{Instances: []*ec2.Instance{test.source}},
+						},
+					}, true)
+				},
+			).Return(test.sourceErr)
+		}
+
+		result, err := restoreInput(context.Background(), mockEC2iface, test.opts, now)
+		ctrl.Finish()
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected an error for %+v but got nil", test.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected nil for %+v but got %v", test.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var (
+		svc = WithRetry(mockEC2iface, RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+		})
+		input = &ec2.RunInstancesInput{
+			ClientToken: aws.String("ami-123456a-1"),
+			ImageId:     aws.String("ami-123456a"),
+			MinCount:    aws.Int64(1),
+			MaxCount:    aws.Int64(1),
+		}
+	)
+
+	// the client token makes a launch safe to retry
+	gomock.InOrder(
+		mockEC2iface.EXPECT().RunInstancesWithContext(gomock.Any(), input).Return(
+			nil,
+			awserr.New("InternalError", "oops", nil),
+		),
+		mockEC2iface.EXPECT().RunInstancesWithContext(gomock.Any(), input).Return(
+			&ec2.Reservation{
+				Instances: []*ec2.Instance{{InstanceId: aws.String("i-5678def")}},
+			},
+			nil,
+		),
+	)
+	id, err := Restore(context.Background(), svc, input)
+	if err != nil || id != "i-5678def" {
+		t.Errorf("Expected i-5678def, nil got %s, %v", id, err)
+	}
+
+	// without one only throttling is retried
+	input.ClientToken = nil
+	mockEC2iface.EXPECT().RunInstancesWithContext(gomock.Any(), input).Return(
+		nil,
+		awserr.New("InternalError", "oops", nil),
+	)
+	if _, err := Restore(context.Background(), svc, input); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
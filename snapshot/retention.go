@@ -0,0 +1,201 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const timestampFormat = "20060102150405"
+
+// backup is one backup the retention policy ranks: an image, or a set of
+// snapshots taken together, with the time it was taken.  id is the image ID
+// or the set's name.
+type backup struct {
+	id        string
+	created   time.Time
+	image     *ec2.Image
+	snapshots []*ec2.Snapshot
+}
+
+type newestFirst []backup
+
+func (b newestFirst) Len() int           { return len(b) }
+func (b newestFirst) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b newestFirst) Less(i, j int) bool { return b[i].created.After(b[j].created) }
+
+// retentionRule decides which backups must survive a prune.  images are
+// sorted newest first and the result maps the id of every backup the rule
+// keeps to a human readable reason.
+type retentionRule interface {
+	keep(images []backup, now time.Time) map[string]string
+}
+
+// retentionPolicy combines rules so that an image is only deleted when no
+// rule wants to keep it.
+type retentionPolicy []retentionRule
+
+func (p retentionPolicy) keep(
+	images []backup,
+	now time.Time,
+) map[string]string {
+	var result = map[string]string{}
+	for _, rule := range p {
+		for id, reason := range rule.keep(images, now) {
+			if _, ok := result[id]; !ok {
+				result[id] = reason
+			}
+		}
+	}
+	return result
+}
+
+// maxAgeRule keeps every image younger than the given number of seconds.
+type maxAgeRule int64
+
+func (r maxAgeRule) keep(
+	images []backup,
+	now time.Time,
+) map[string]string {
+	var result = map[string]string{}
+	for _, b := range images {
+		if now.Unix()-b.created.Unix() <= int64(r) {
+			result[b.id] = fmt.Sprintf(
+				"younger than time-to-save of %ds",
+				int64(r),
+			)
+		}
+	}
+	return result
+}
+
+// keepLastRule keeps the given number of newest images.
+type keepLastRule int
+
+func (r keepLastRule) keep(
+	images []backup,
+	now time.Time,
+) map[string]string {
+	var result = map[string]string{}
+	for i, b := range images {
+		if i >= int(r) {
+			break
+		}
+		result[b.id] = fmt.Sprintf("one of the %d newest", int(r))
+	}
+	return result
+}
+
+// Schedule is a grandfather-father-son rule keeping the newest image of
+// each of the last Daily days, Weekly ISO weeks, Monthly months and Yearly
+// years that have a backup.
+type Schedule struct {
+	Daily   int `yaml:"daily"`
+	Weekly  int `yaml:"weekly"`
+	Monthly int `yaml:"monthly"`
+	Yearly  int `yaml:"yearly"`
+}
+
+func (g Schedule) keep(
+	images []backup,
+	now time.Time,
+) map[string]string {
+	var (
+		result = map[string]string{}
+		tiers  = []struct {
+			name   string
+			count  int
+			bucket func(time.Time) string
+		}{
+			{"daily", g.Daily, func(t time.Time) string {
+				return t.Format("2006-01-02")
+			}},
+			{"weekly", g.Weekly, func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-W%02d", year, week)
+			}},
+			{"monthly", g.Monthly, func(t time.Time) string {
+				return t.Format("2006-01")
+			}},
+			{"yearly", g.Yearly, func(t time.Time) string {
+				return t.Format("2006")
+			}},
+		}
+	)
+	for _, tier := range tiers {
+		var seen = map[string]bool{}
+		for _, b := range images {
+			if len(seen) >= tier.count {
+				break
+			}
+			bucket := tier.bucket(b.created.In(time.Local))
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			if _, ok := result[b.id]; !ok {
+				result[b.id] = fmt.Sprintf(
+					"%s backup for %s",
+					tier.name,
+					bucket,
+				)
+			}
+		}
+	}
+	return result
+}
+
+// Validate rejects negative counts.
+func (g Schedule) Validate() error {
+	if g.Daily < 0 || g.Weekly < 0 || g.Monthly < 0 || g.Yearly < 0 {
+		return fmt.Errorf("Retention counts must not be negative, got %+v", g)
+	}
+	return nil
+}
+
+func (g Schedule) isZero() bool {
+	return g == Schedule{}
+}
+
+// ValidateRetention rejects a retention policy that keeps nothing: with a
+// timeToSave of 0 seconds and neither keepLast nor schedule set, every
+// backup is pruned, including the one just taken.
+func ValidateRetention(timeToSave int64, keepLast int, schedule Schedule) error {
+	if timeToSave == 0 && keepLast == 0 && schedule.isZero() {
+		return fmt.Errorf(
+			"TimeToSave, KeepLast or Schedule must be set, or every backup is pruned",
+		)
+	}
+	return nil
+}
+
+// backupTime returns when the backup was taken, preferring the timestamp
+// suffix added by createNameWithTimestamp and falling back to the image's
+// CreationDate.
+func backupTime(image *ec2.Image) (time.Time, error) {
+	if image.Name != nil {
+		if _, t, ok := splitTimestampedName(*image.Name); ok {
+			return t, nil
+		}
+	}
+	if image.CreationDate == nil {
+		return time.Time{}, fmt.Errorf("Image %s has no creation date", *image.ImageId)
+	}
+	return time.Parse(time.RFC3339, *image.CreationDate)
+}
+
+// splitTimestampedName splits a name made by createNameWithTimestamp into
+// its prefix and timestamp.  ok is false for any other name.
+func splitTimestampedName(name string) (string, time.Time, bool) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 || len(name)-i-1 != len(timestampFormat) {
+		return "", time.Time{}, false
+	}
+	t, err := time.ParseInLocation(timestampFormat, name[i+1:], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return name[:i], t, true
+}
@@ -0,0 +1,216 @@
+package snapshot
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func getBackupImages(now time.Time, ages ...time.Duration) []backup {
+	var result = []backup{}
+	for i, age := range ages {
+		created := now.Add(-age)
+		result = append(result, backup{
+			id: string(rune('a' + i)),
+			image: &ec2.Image{
+				ImageId: aws.String(string(rune('a' + i))),
+				Name: aws.String(
+					"testing1.bak." + created.Format(timestampFormat),
+				),
+			},
+			created: created,
+		})
+	}
+	sort.Sort(newestFirst(result))
+	return result
+}
+
+func keptIDs(kept map[string]string) []string {
+	var result = []string{}
+	for id := range kept {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestGFSSchedule(t *testing.T) {
+	var (
+		now = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.Local)
+		day = 24 * time.Hour
+	)
+
+	var tests = []struct {
+		schedule Schedule
+		images   []backup
+		expect   []string
+	}{
+		{
+			schedule: Schedule{Daily: 2},
+			// a and b share a day, so only the newer of them is kept
+			images: getBackupImages(now, time.Hour, 2*time.Hour, day, 2*day),
+			expect: []string{"a", "c"},
+		},
+		{
+			schedule: Schedule{Weekly: 2},
+			// 2016-03-15 is a Tuesday: a and b fall in the same ISO week
+			images: getBackupImages(now, 0, day, 7*day, 8*day, 14*day),
+			expect: []string{"a", "c"},
+		},
+		{
+			schedule: Schedule{Daily: 1, Monthly: 3, Yearly: 2},
+			images: getBackupImages(
+				now,
+				0,
+				20*day,
+				40*day,
+				80*day,
+				400*day,
+			),
+			// c shares February with b and e shares 2015 with d
+			expect: []string{"a", "b", "d"},
+		},
+		{
+			schedule: Schedule{},
+			images:   getBackupImages(now, 0, day),
+			expect:   []string{},
+		},
+	}
+
+	for _, test := range tests {
+		result := keptIDs(test.schedule.keep(test.images, now))
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+
+	if err := (Schedule{Daily: -1}).Validate(); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	var (
+		now    = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.Local)
+		day    = 24 * time.Hour
+		images = getBackupImages(now, 0, 2*day, 10*day, 40*day, 100*day)
+	)
+
+	var tests = []struct {
+		policy retentionPolicy
+		expect []string
+	}{
+		{
+			policy: retentionPolicy{maxAgeRule(7 * 86400)},
+			expect: []string{"a", "b"},
+		},
+		{
+			policy: retentionPolicy{keepLastRule(3)},
+			expect: []string{"a", "b", "c"},
+		},
+		{
+			policy: retentionPolicy{maxAgeRule(86400), keepLastRule(2)},
+			expect: []string{"a", "b"},
+		},
+		{
+			policy: retentionPolicy{
+				maxAgeRule(7 * 86400),
+				Schedule{Monthly: 12},
+			},
+			expect: []string{"a", "b", "d", "e"},
+		},
+		{
+			policy: retentionPolicy{maxAgeRule(0)},
+			expect: []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		result := keptIDs(test.policy.keep(images, now))
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+
+	kept := retentionPolicy{
+		keepLastRule(1),
+		maxAgeRule(7 * 86400),
+	}.keep(images, now)
+	if kept["a"] != "one of the 1 newest" {
+		t.Errorf("Expected the first rule's reason but got %s", kept["a"])
+	}
+}
+
+func TestBackupTime(t *testing.T) {
+	var tests = []struct {
+		image  *ec2.Image
+		expect time.Time
+	}{
+		{
+			image: &ec2.Image{
+				ImageId:      aws.String("ami-123456a"),
+				Name:         aws.String("testing1.bak.20160102030405"),
+				CreationDate: aws.String("2016-05-06T07:08:09.000Z"),
+			},
+			expect: time.Date(2016, time.January, 2, 3, 4, 5, 0, time.Local),
+		},
+		{
+			image: &ec2.Image{
+				ImageId:      aws.String("ami-123456b"),
+				Name:         aws.String("testing1.bak.848590424"),
+				CreationDate: aws.String("2016-05-06T07:08:09.000Z"),
+			},
+			expect: time.Date(2016, time.May, 6, 7, 8, 9, 0, time.UTC),
+		},
+		{
+			image: &ec2.Image{
+				ImageId:      aws.String("ami-123456c"),
+				Name:         aws.String("testing1.bak.20161399999999"),
+				CreationDate: aws.String("2016-05-06T07:08:09.000Z"),
+			},
+			expect: time.Date(2016, time.May, 6, 7, 8, 9, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := backupTime(test.image)
+		if err != nil {
+			t.Errorf("Expected nil but got %v", err)
+		}
+		if !result.Equal(test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+
+	if _, err := backupTime(&ec2.Image{
+		ImageId:      aws.String("ami-123456d"),
+		Name:         aws.String("testing1.bak"),
+		CreationDate: aws.String("yesterday"),
+	}); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	var tests = []struct {
+		timeToSave int64
+		keepLast   int
+		schedule   Schedule
+		fail       bool
+	}{
+		{timeToSave: 604800},
+		{keepLast: 1},
+		{schedule: Schedule{Daily: 7}},
+		{fail: true},
+	}
+	for _, test := range tests {
+		err := ValidateRetention(test.timeToSave, test.keepLast, test.schedule)
+		if (err != nil) != test.fail {
+			t.Errorf("Expected failure %t for %+v got %v", test.fail, test, err)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package snapshot
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// DefaultMaxAttempts is how many times an EC2 call is made when
+	// RetryPolicy.MaxAttempts is not set.
+	DefaultMaxAttempts = 8
+	// DefaultRetryBudget is how long an EC2 call may keep being retried when
+	// RetryPolicy.Budget is not set.
+	DefaultRetryBudget = 5 * time.Minute
+
+	defaultBaseDelay = time.Second
+	maxRetryDelay    = 30 * time.Second
+)
+
+// RetryPolicy controls how EC2 calls that fail for a passing reason, such
+// as throttling, are retried.  MaxAttempts counts the first call, so 1
+// turns retrying off.  Budget bounds the time spent on one call including
+// the backoff between attempts.  BaseDelay is the backoff before the second
+// attempt and doubles for each one after.  Zero values take the defaults.
+type RetryPolicy struct {
+	MaxAttempts int
+	Budget      time.Duration
+	BaseDelay   time.Duration
+}
+
+// Validate reports the first problem with p.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 || p.Budget < 0 || p.BaseDelay < 0 {
+		return fmt.Errorf(
+			"MaxAttempts, Budget and BaseDelay must not be negative",
+		)
+	}
+	return nil
+}
+
+// errorClass says why an EC2 call failed, as far as retrying is concerned.
+type errorClass int
+
+const (
+	permanentError errorClass = iota
+	throttledError
+	inUseError
+	transientError
+)
+
+var errorClasses = map[string]errorClass{
+	"RequestLimitExceeded":      throttledError,
+	"Throttling":                throttledError,
+	"ThrottlingException":       throttledError,
+	"RequestThrottled":          throttledError,
+	"RequestThrottledException": throttledError,
+	"TooManyRequestsException":  throttledError,
+	"InvalidSnapshot.InUse":     inUseError,
+	"InternalError":             transientError,
+	"InternalFailure":           transientError,
+	"ServiceUnavailable":        transientError,
+	"Unavailable":               transientError,
+	"RequestError":              transientError,
+	"RequestTimeout":            transientError,
+}
+
+// classify returns the class of err.  Errors without a known code are
+// transient if AWS answered with a 5xx status.
+func classify(err error) errorClass {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return permanentError
+	}
+	if class, ok := errorClasses[awsErr.Code()]; ok {
+		return class
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok &&
+		reqErr.StatusCode() >= 500 {
+		return transientError
+	}
+	return permanentError
+}
+
+// hasCode reports whether err is an AWS error with code.
+func hasCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
+// retryingEC2 retries the EC2 calls this package makes according to policy.
+// Calls it does not override go straight to the wrapped EC2API.
+type retryingEC2 struct {
+	ec2iface.EC2API
+	policy RetryPolicy
+}
+
+// WithRetry returns svc with the calls this package makes retried
+// according to policy.  Wrapping an already wrapped svc replaces its policy.
+func WithRetry(svc ec2iface.EC2API, policy RetryPolicy) ec2iface.EC2API {
+	if r, ok := svc.(*retryingEC2); ok {
+		svc = r.EC2API
+	}
+	return &retryingEC2{svc, policy}
+}
+
+// do makes call until it succeeds, fails for a reason that is not worth
+// retrying, or the policy runs out.  Calls that are not idempotent are only
+// retried when throttled, since AWS never started on those.  The error of
+// the last attempt is returned as is, so callers can still inspect it.
+func (r *retryingEC2) do(
+	ctx aws.Context,
+	idempotent bool,
+	call func(attempt int) error,
+) error {
+	var (
+		maxAttempts = r.policy.MaxAttempts
+		budget      = r.policy.Budget
+		delay       = r.policy.BaseDelay
+	)
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if budget == 0 {
+		budget = DefaultRetryBudget
+	}
+	if delay == 0 {
+		delay = defaultBaseDelay
+	}
+	deadline := time.Now().Add(budget)
+	for attempt := 1; ; attempt++ {
+		err := call(attempt)
+		if err == nil {
+			return nil
+		}
+		class := classify(err)
+		if class == permanentError ||
+			(!idempotent && class != throttledError) ||
+			attempt >= maxAttempts {
+			return err
+		}
+		// equal jitter keeps the cron jobs that failed together from
+		// retrying together
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (r *retryingEC2) CopyImageWithContext(
+	ctx aws.Context,
+	input *ec2.CopyImageInput,
+	opts ...request.Option,
+) (*ec2.CopyImageOutput, error) {
+	var out *ec2.CopyImageOutput
+	err := r.do(ctx, false, func(int) (err error) {
+		out, err = r.EC2API.CopyImageWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingEC2) CreateImageWithContext(
+	ctx aws.Context,
+	input *ec2.CreateImageInput,
+	opts ...request.Option,
+) (*ec2.CreateImageOutput, error) {
+	var out *ec2.CreateImageOutput
+	err := r.do(ctx, false, func(int) (err error) {
+		out, err = r.EC2API.CreateImageWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingEC2) CreateSnapshotWithContext(
+	ctx aws.Context,
+	input *ec2.CreateSnapshotInput,
+	opts ...request.Option,
+) (*ec2.Snapshot, error) {
+	var out *ec2.Snapshot
+	err := r.do(ctx, false, func(int) (err error) {
+		out, err = r.EC2API.CreateSnapshotWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingEC2) CreateSnapshotsWithContext(
+	ctx aws.Context,
+	input *ec2.CreateSnapshotsInput,
+	opts ...request.Option,
+) (*ec2.CreateSnapshotsOutput, error) {
+	var out *ec2.CreateSnapshotsOutput
+	err := r.do(ctx, false, func(int) (err error) {
+		out, err = r.EC2API.CreateSnapshotsWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingEC2) CreateTagsWithContext(
+	ctx aws.Context,
+	input *ec2.CreateTagsInput,
+	opts ...request.Option,
+) (*ec2.CreateTagsOutput, error) {
+	var out *ec2.CreateTagsOutput
+	err := r.do(ctx, true, func(int) (err error) {
+		out, err = r.EC2API.CreateTagsWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// DeleteSnapshotWithContext treats a snapshot that has disappeared by the
+// time of a retry as deleted, since an earlier attempt may have gone
+// through before failing.
+func (r *retryingEC2) DeleteSnapshotWithContext(
+	ctx aws.Context,
+	input *ec2.DeleteSnapshotInput,
+	opts ...request.Option,
+) (*ec2.DeleteSnapshotOutput, error) {
+	var out *ec2.DeleteSnapshotOutput
+	err := r.do(ctx, true, func(attempt int) (err error) {
+		out, err = r.EC2API.DeleteSnapshotWithContext(ctx, input, opts...)
+		if attempt > 1 && hasCode(err, "InvalidSnapshot.NotFound") {
+			out, err = &ec2.DeleteSnapshotOutput{}, nil
+		}
+		return err
+	})
+	return out, err
+}
+
+// DeregisterImageWithContext treats an image that has disappeared by the
+// time of a retry as deregistered, like DeleteSnapshotWithContext.
+func (r *retryingEC2) DeregisterImageWithContext(
+	ctx aws.Context,
+	input *ec2.DeregisterImageInput,
+	opts ...request.Option,
+) (*ec2.DeregisterImageOutput, error) {
+	var out *ec2.DeregisterImageOutput
+	err := r.do(ctx, true, func(attempt int) (err error) {
+		out, err = r.EC2API.DeregisterImageWithContext(ctx, input, opts...)
+		if attempt > 1 && hasCode(err, "InvalidAMIID.NotFound") {
+			out, err = &ec2.DeregisterImageOutput{}, nil
+		}
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingEC2) DescribeImagesWithContext(
+	ctx aws.Context,
+	input *ec2.DescribeImagesInput,
+	opts ...request.Option,
+) (*ec2.DescribeImagesOutput, error) {
+	var out *ec2.DescribeImagesOutput
+	err := r.do(ctx, true, func(int) (err error) {
+		out, err = r.EC2API.DescribeImagesWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// DescribeImagesPagesWithContext starts a failed listing over, skipping the
+// pages fn has already seen.
+func (r *retryingEC2) DescribeImagesPagesWithContext(
+	ctx aws.Context,
+	input *ec2.DescribeImagesInput,
+	fn func(*ec2.DescribeImagesOutput, bool) bool,
+	opts ...request.Option,
+) error {
+	var seen int
+	return r.do(ctx, true, func(int) error {
+		var page int
+		return r.EC2API.DescribeImagesPagesWithContext(
+			ctx,
+			input,
+			func(out *ec2.DescribeImagesOutput, lastPage bool) bool {
+				if page++; page <= seen {
+					return true
+				}
+				seen++
+				return fn(out, lastPage)
+			},
+			opts...,
+		)
+	})
+}
+
+// DescribeInstancesPagesWithContext starts a failed listing over, like
+// DescribeImagesPagesWithContext.
+func (r *retryingEC2) DescribeInstancesPagesWithContext(
+	ctx aws.Context,
+	input *ec2.DescribeInstancesInput,
+	fn func(*ec2.DescribeInstancesOutput, bool) bool,
+	opts ...request.Option,
+) error {
+	var seen int
+	return r.do(ctx, true, func(int) error {
+		var page int
+		return r.EC2API.DescribeInstancesPagesWithContext(
+			ctx,
+			input,
+			func(out *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				if page++; page <= seen {
+					return true
+				}
+				seen++
+				return fn(out, lastPage)
+			},
+			opts...,
+		)
+	})
+}
+
+// DescribeSnapshotsPagesWithContext starts a failed listing over, like
+// DescribeImagesPagesWithContext.
+func (r *retryingEC2) DescribeSnapshotsPagesWithContext(
+	ctx aws.Context,
+	input *ec2.DescribeSnapshotsInput,
+	fn func(*ec2.DescribeSnapshotsOutput, bool) bool,
+	opts ...request.Option,
+) error {
+	var seen int
+	return r.do(ctx, true, func(int) error {
+		var page int
+		return r.EC2API.DescribeSnapshotsPagesWithContext(
+			ctx,
+			input,
+			func(out *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+				if page++; page <= seen {
+					return true
+				}
+				seen++
+				return fn(out, lastPage)
+			},
+			opts...,
+		)
+	})
+}
+
+// DescribeVolumesPagesWithContext starts a failed listing over, like
+// DescribeImagesPagesWithContext.
+func (r *retryingEC2) DescribeVolumesPagesWithContext(
+	ctx aws.Context,
+	input *ec2.DescribeVolumesInput,
+	fn func(*ec2.DescribeVolumesOutput, bool) bool,
+	opts ...request.Option,
+) error {
+	var seen int
+	return r.do(ctx, true, func(int) error {
+		var page int
+		return r.EC2API.DescribeVolumesPagesWithContext(
+			ctx,
+			input,
+			func(out *ec2.DescribeVolumesOutput, lastPage bool) bool {
+				if page++; page <= seen {
+					return true
+				}
+				seen++
+				return fn(out, lastPage)
+			},
+			opts...,
+		)
+	})
+}
+
+func (r *retryingEC2) ModifyImageAttributeWithContext(
+	ctx aws.Context,
+	input *ec2.ModifyImageAttributeInput,
+	opts ...request.Option,
+) (*ec2.ModifyImageAttributeOutput, error) {
+	var out *ec2.ModifyImageAttributeOutput
+	err := r.do(ctx, true, func(int) (err error) {
+		out, err = r.EC2API.ModifyImageAttributeWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingEC2) ModifySnapshotAttributeWithContext(
+	ctx aws.Context,
+	input *ec2.ModifySnapshotAttributeInput,
+	opts ...request.Option,
+) (*ec2.ModifySnapshotAttributeOutput, error) {
+	var out *ec2.ModifySnapshotAttributeOutput
+	err := r.do(ctx, true, func(int) (err error) {
+		out, err = r.EC2API.ModifySnapshotAttributeWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// RunInstancesWithContext is only retried past throttling when the input
+// carries a ClientToken, with which AWS launches the instance at most once.
+func (r *retryingEC2) RunInstancesWithContext(
+	ctx aws.Context,
+	input *ec2.RunInstancesInput,
+	opts ...request.Option,
+) (*ec2.Reservation, error) {
+	var out *ec2.Reservation
+	err := r.do(ctx, aws.StringValue(input.ClientToken) != "", func(int) (err error) {
+		out, err = r.EC2API.RunInstancesWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
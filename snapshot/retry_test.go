@@ -0,0 +1,231 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestClassify(t *testing.T) {
+	var tests = []struct {
+		err    error
+		expect errorClass
+	}{
+		{awserr.New("RequestLimitExceeded", "slow down", nil), throttledError},
+		{awserr.New("InvalidSnapshot.InUse", "in use", nil), inUseError},
+		{awserr.New("InternalError", "oops", nil), transientError},
+		{
+			awserr.NewRequestFailure(
+				awserr.New("SomethingNew", "bad gateway", nil),
+				502,
+				"req-1",
+			),
+			transientError,
+		},
+		{
+			awserr.NewRequestFailure(
+				awserr.New("InvalidAMIID.NotFound", "not found", nil),
+				400,
+				"req-1",
+			),
+			permanentError,
+		},
+		{errors.New("Some error blah blah"), permanentError},
+	}
+	for _, test := range tests {
+		if result := classify(test.err); result != test.expect {
+			t.Errorf("Expected %d for %v got %d", test.expect, test.err, result)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var (
+		svc = WithRetry(mockEC2iface, RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+		})
+		throttled  = awserr.New("RequestLimitExceeded", "slow down", nil)
+		inUse      = awserr.New("InvalidSnapshot.InUse", "in use", nil)
+		internal   = awserr.New("InternalError", "oops", nil)
+		deregister = &ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		}
+		deleteSnapshot = &ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1"),
+			DryRun:     aws.Bool(false),
+		}
+		create = &ec2.CreateImageInput{
+			Name:       aws.String("testing1.bak.1257894000"),
+			InstanceId: aws.String("i-1234abc"),
+		}
+	)
+
+	// throttled, then through
+	gomock.InOrder(
+		mockEC2iface.EXPECT().DeregisterImageWithContext(gomock.Any(), deregister).Return(
+			nil,
+			throttled,
+		),
+		mockEC2iface.EXPECT().DeregisterImageWithContext(gomock.Any(), deregister).Return(
+			&ec2.DeregisterImageOutput{},
+			nil,
+		),
+	)
+	if _, err := svc.DeregisterImageWithContext(context.Background(), deregister); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+
+	// still in use after every attempt: the last error is returned as is
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(gomock.Any(), deleteSnapshot).Return(
+		nil,
+		inUse,
+	).Times(3)
+	if _, err := svc.DeleteSnapshotWithContext(context.Background(), deleteSnapshot); err != inUse {
+		t.Errorf("Expected %v but got %v", inUse, err)
+	}
+
+	// gone by the time of the retry: an earlier attempt went through
+	gomock.InOrder(
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(gomock.Any(), deleteSnapshot).Return(
+			nil,
+			internal,
+		),
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(gomock.Any(), deleteSnapshot).Return(
+			nil,
+			awserr.New("InvalidSnapshot.NotFound", "not found", nil),
+		),
+	)
+	if _, err := svc.DeleteSnapshotWithContext(context.Background(), deleteSnapshot); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+
+	// permanent errors are not retried
+	mockEC2iface.EXPECT().DeregisterImageWithContext(gomock.Any(), deregister).Return(
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := svc.DeregisterImageWithContext(context.Background(), deregister); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+
+	// CreateImage is retried when throttled but not after a 5xx, which may
+	// have created the image anyway
+	gomock.InOrder(
+		mockEC2iface.EXPECT().CreateImageWithContext(gomock.Any(), create).Return(
+			nil,
+			throttled,
+		),
+		mockEC2iface.EXPECT().CreateImageWithContext(gomock.Any(), create).Return(
+			nil,
+			internal,
+		),
+	)
+	if _, err := svc.CreateImageWithContext(context.Background(), create); err != internal {
+		t.Errorf("Expected %v but got %v", internal, err)
+	}
+
+	// a cancelled context stops the backoff
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockEC2iface.EXPECT().DeregisterImageWithContext(gomock.Any(), deregister).Return(
+		nil,
+		throttled,
+	)
+	if _, err := svc.DeregisterImageWithContext(ctx, deregister); err != throttled {
+		t.Errorf("Expected %v but got %v", throttled, err)
+	}
+
+	// the budget stops the backoff too
+	svc = WithRetry(svc, RetryPolicy{
+		Budget:    time.Millisecond,
+		BaseDelay: time.Hour,
+	})
+	mockEC2iface.EXPECT().DeregisterImageWithContext(gomock.Any(), deregister).Return(
+		nil,
+		throttled,
+	)
+	if _, err := svc.DeregisterImageWithContext(context.Background(), deregister); err != throttled {
+		t.Errorf("Expected %v but got %v", throttled, err)
+	}
+}
+
+func TestRetryPages(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var (
+		svc   = WithRetry(mockEC2iface, RetryPolicy{BaseDelay: time.Millisecond})
+		input = &ec2.DescribeSnapshotsInput{
+			OwnerIds: aws.StringSlice([]string{"self"}),
+		}
+		pages = []*ec2.DescribeSnapshotsOutput{
+			{Snapshots: []*ec2.Snapshot{{SnapshotId: aws.String("snap-1")}}},
+			{Snapshots: []*ec2.Snapshot{{SnapshotId: aws.String("snap-2")}}},
+		}
+	)
+
+	// the second page is throttled, the retry starts over
+	gomock.InOrder(
+		mockEC2iface.EXPECT().DescribeSnapshotsPagesWithContext(
+			gomock.Any(),
+			input,
+			gomock.Any(),
+		).Do(
+			func(
+				_ aws.Context,
+				_ *ec2.DescribeSnapshotsInput,
+				fn func(*ec2.DescribeSnapshotsOutput, bool) bool,
+				_ ...request.Option,
+			) {
+				fn(pages[0], false)
+			},
+		).Return(awserr.New("RequestLimitExceeded", "slow down", nil)),
+		mockEC2iface.EXPECT().DescribeSnapshotsPagesWithContext(
+			gomock.Any(),
+			input,
+			gomock.Any(),
+		).Do(
+			func(
+				_ aws.Context,
+				_ *ec2.DescribeSnapshotsInput,
+				fn func(*ec2.DescribeSnapshotsOutput, bool) bool,
+				_ ...request.Option,
+			) {
+				if fn(pages[0], false) {
+					fn(pages[1], true)
+				}
+			},
+		).Return(nil),
+	)
+	var result []string
+	err := svc.DescribeSnapshotsPagesWithContext(
+		context.Background(),
+		input,
+		func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			for _, snapshot := range page.Snapshots {
+				result = append(result, *snapshot.SnapshotId)
+			}
+			return true
+		},
+	)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	var expect = []string{"snap-1", "snap-2"}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %v got %v", expect, result)
+	}
+}
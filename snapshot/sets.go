@@ -0,0 +1,340 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Modes a Client backs up in.  ModeImage, the default, takes an AMI of the
+// instance.  ModeSnapshots takes a crash-consistent set of EBS snapshots of
+// its volumes with CreateSnapshots, for when only the data is needed; the
+// set is named and pruned like an image would be.  ModeVolume backs up a
+// single volume, rather than an instance, as a set of one snapshot.
+const (
+	ModeImage     = "image"
+	ModeSnapshots = "snapshots"
+	ModeVolume    = "volume"
+)
+
+// createSnapshotSet snapshots the volumes of instanceID as one set named
+// s.imageName, waits for every snapshot to complete and prunes old sets.
+func (s *Client) createSnapshotSet(
+	ctx context.Context,
+	instanceID string,
+	now time.Time,
+) (string, error) {
+	input, devices, err := s.createSnapshotsInput(ctx, instanceID, now)
+	if err != nil {
+		return "", &CreateError{s.imageName, err.Error()}
+	}
+	var (
+		out  *ec2.CreateSnapshotsOutput
+		errs MultiError
+	)
+	err = s.quiesce(ctx, instanceID, func() error {
+		var err error
+		out, err = s.svc.CreateSnapshotsWithContext(ctx, input)
+		return err
+	})
+	if out == nil {
+		return "", &CreateError{
+			s.imageName,
+			fmt.Sprintf("Failed to create snapshots b/c of %s", err.Error()),
+		}
+	}
+	if err != nil {
+		errs = append(errs, &CreateError{s.imageName, err.Error()})
+	}
+	if len(out.Snapshots) == 0 {
+		errs = append(errs, &CreateError{s.imageName, "No volumes were left to snapshot"})
+		return "", errs
+	}
+	s.newImageID = s.imageName
+	var ids = []string{}
+	for _, info := range out.Snapshots {
+		ids = append(ids, *info.SnapshotId)
+	}
+	if err := s.tagDevices(ctx, out.Snapshots, devices); err != nil {
+		return s.imageName, append(errs, &CreateError{s.imageName, err.Error()})
+	}
+	if err := s.waitForSnapshots(ctx, ids); err != nil {
+		return s.imageName, append(errs, err)
+	}
+	if err := s.removeOldSnapshotSets(ctx); err != nil {
+		errs = append(errs, &DeleteError{s.imageName, err.Error()})
+	}
+	return s.imageName, errs.errorOrNil()
+}
+
+// createSnapshotsInput returns the request that snapshots instanceID, and
+// the device name of each of its volumes.  Excluded devices that are not
+// attached to the instance are ignored, so one list can serve every
+// instance discovered by tag.
+func (s *Client) createSnapshotsInput(
+	ctx context.Context,
+	instanceID string,
+	now time.Time,
+) (*ec2.CreateSnapshotsInput, map[string]string, error) {
+	instance, err := s.describeInstance(ctx, instanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		devices     = map[string]string{}
+		excluded    = map[string]bool{}
+		excludeBoot = s.excludeBootVolume
+		excludeIDs  []*string
+	)
+	for _, device := range s.excludeDevices {
+		excluded[device] = true
+	}
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.Ebs == nil || mapping.Ebs.VolumeId == nil {
+			continue
+		}
+		device := aws.StringValue(mapping.DeviceName)
+		devices[*mapping.Ebs.VolumeId] = device
+		if !excluded[device] {
+			continue
+		}
+		// CreateSnapshots only takes data volumes in ExcludeDataVolumeIds
+		if device == aws.StringValue(instance.RootDeviceName) {
+			excludeBoot = true
+			continue
+		}
+		excludeIDs = append(excludeIDs, mapping.Ebs.VolumeId)
+	}
+	return &ec2.CreateSnapshotsInput{
+		Description: aws.String(fmt.Sprintf(
+			"Created by %s for %s from %s",
+			managedByValue,
+			s.imageName,
+			instanceID,
+		)),
+		InstanceSpecification: &ec2.InstanceSpecification{
+			InstanceId:           aws.String(instanceID),
+			ExcludeBootVolume:    aws.Bool(excludeBoot),
+			ExcludeDataVolumeIds: excludeIDs,
+		},
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+				Tags:         s.snapshotSetTags(instanceID, now),
+			},
+		},
+	}, devices, nil
+}
+
+// describeInstance returns instanceID as seen by DescribeInstances.
+func (s *Client) describeInstance(
+	ctx context.Context,
+	instanceID string,
+) (*ec2.Instance, error) {
+	var result *ec2.Instance
+	err := s.svc.DescribeInstancesPagesWithContext(
+		ctx,
+		&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{aws.String(instanceID)},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					result = instance
+					return false
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to describe instance %s b/c of %s",
+			instanceID,
+			err.Error(),
+		)
+	}
+	if result == nil {
+		return nil, fmt.Errorf("Instance %s was not found", instanceID)
+	}
+	return result, nil
+}
+
+// tagDevices tags each new snapshot with the device name its volume is
+// attached as, which CreateSnapshots cannot do as it tags them all alike.
+func (s *Client) tagDevices(
+	ctx context.Context,
+	snapshots []*ec2.SnapshotInfo,
+	devices map[string]string,
+) error {
+	for _, info := range snapshots {
+		device, ok := devices[aws.StringValue(info.VolumeId)]
+		if !ok {
+			continue
+		}
+		_, err := s.svc.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{info.SnapshotId},
+			Tags: []*ec2.Tag{
+				{Key: aws.String(tagDevice), Value: aws.String(device)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf(
+				"Failed to tag snapshot %s b/c of %s",
+				*info.SnapshotId,
+				err.Error(),
+			)
+		}
+	}
+	return nil
+}
+
+// removeOldSnapshotSets deletes every snapshot set the retention policy
+// does not keep, like removeOldImage does for images.
+func (s *Client) removeOldSnapshotSets(ctx context.Context) error {
+	sets, kept, err := s.snapshotSetCandidates(ctx)
+	if sets == nil {
+		return err
+	}
+	var errs MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, b := range sets {
+		if _, ok := kept[b.id]; ok || s.newImageID == b.id {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, &DeleteError{
+				s.imageName,
+				fmt.Sprintf("Stopped pruning b/c of %s", err.Error()),
+			})
+			break
+		}
+		if err := s.deleteSnapshotSet(ctx, b); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// deleteSnapshotSet deletes every snapshot in set.  Like deleteImage it
+// runs to completion once started, so no set is left half deleted.
+func (s *Client) deleteSnapshotSet(ctx context.Context, set backup) error {
+	ctx, cancel := withoutCancel(ctx)
+	defer cancel()
+	var firstErr error
+	for _, snapshot := range set.snapshots {
+		_, err := s.svc.DeleteSnapshotWithContext(
+			ctx,
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: snapshot.SnapshotId,
+				DryRun:     aws.Bool(false),
+			},
+		)
+		if err != nil && firstErr == nil {
+			firstErr = &DeleteError{
+				set.id,
+				fmt.Sprintf(
+					"Failed to delete snapshot %s b/c of %s",
+					*snapshot.SnapshotId,
+					err.Error(),
+				),
+			}
+		}
+	}
+	return firstErr
+}
+
+// snapshotSetCandidates is pruneCandidates for snapshot sets.  Snapshots
+// are grouped by their set tag and each set is ranked by the timestamp in
+// its name.
+func (s *Client) snapshotSetCandidates(
+	ctx context.Context,
+	extra ...backup,
+) ([]backup, map[string]string, error) {
+	var (
+		grouped = map[string][]*ec2.Snapshot{}
+		sets    = append([]backup{}, extra...)
+		errs    MultiError
+	)
+	err := s.svc.DescribeSnapshotsPagesWithContext(
+		ctx,
+		s.describeSnapshotSetsInput(),
+		func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			for _, snapshot := range page.Snapshots {
+				if getTagValue(snapshot.Tags, tagManagedBy) != managedByValue ||
+					getTagValue(snapshot.Tags, tagPolicy) != s.imageNameWithoutTimestamp {
+					continue
+				}
+				name := getTagValue(snapshot.Tags, tagSet)
+				if name != "" {
+					grouped[name] = append(grouped[name], snapshot)
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, nil, &DeleteError{
+			s.imageName,
+			fmt.Sprintf("Failed to describe snapshots with error %s", err.Error()),
+		}
+	}
+	var names = []string{}
+	for name := range grouped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, created, ok := splitTimestampedName(name)
+		if !ok {
+			errs = append(errs, &DeleteError{
+				name,
+				"Skipped snapshot set with unknown backup time",
+			})
+			continue
+		}
+		sets = append(sets, backup{
+			id:        name,
+			created:   created,
+			snapshots: grouped[name],
+		})
+	}
+	sort.Sort(newestFirst(sets))
+	return sets, s.retentionPolicy().keep(sets, time.Now()), errs.errorOrNil()
+}
+
+// describeSnapshotSetsInput asks for this account's snapshots in a set
+// taken for imageNameWithoutTimestamp, on top of the configured filters.
+func (s *Client) describeSnapshotSetsInput() *ec2.DescribeSnapshotsInput {
+	return &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters: append(
+			append([]*ec2.Filter{}, s.filter...),
+			&ec2.Filter{
+				Name:   aws.String("tag:" + tagPolicy),
+				Values: []*string{aws.String(s.imageNameWithoutTimestamp)},
+			},
+			&ec2.Filter{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(tagSet)},
+			},
+		),
+	}
+}
+
+// setState returns the state of a snapshot set: completed once all of its
+// snapshots are, else the state of the first one that is not.
+func setState(snapshots []*ec2.Snapshot) string {
+	for _, snapshot := range snapshots {
+		if state := aws.StringValue(snapshot.State); state != ec2.SnapshotStateCompleted {
+			return state
+		}
+	}
+	return ec2.SnapshotStateCompleted
+}
@@ -0,0 +1,433 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+// getSetSnapshot returns a completed snapshot in the set named name taken
+// for policy.
+func getSetSnapshot(id, policy, name string) *ec2.Snapshot {
+	return &ec2.Snapshot{
+		SnapshotId: aws.String(id),
+		State:      aws.String(ec2.SnapshotStateCompleted),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(tagManagedBy), Value: aws.String(managedByValue)},
+			{Key: aws.String(tagPolicy), Value: aws.String(policy)},
+			{Key: aws.String(tagSet), Value: aws.String(name)},
+		},
+	}
+}
+
+func TestCreateSnapshotSet(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var (
+		now = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.UTC)
+		s   = &Client{
+			svc:                       mockEC2iface,
+			imageNameWithoutTimestamp: "testing1.bak",
+			imageName:                 "testing1.bak.20160315120000",
+			timeToSave:                604800,
+			waitTimeout:               time.Second,
+			pollInterval:              time.Millisecond,
+			excludeDevices:            []string{"/dev/xvda", "/dev/sdg", "/dev/sdz"},
+		}
+		ids = aws.StringSlice([]string{"snap-1"})
+	)
+
+	mockEC2iface.EXPECT().DescribeInstancesPagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice([]string{"i-1234abc"}),
+		},
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeInstancesInput,
+			fn func(*ec2.DescribeInstancesOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			fn(&ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					{
+						Instances: []*ec2.Instance{
+							{
+								InstanceId:     aws.String("i-1234abc"),
+								RootDeviceName: aws.String("/dev/xvda"),
+								BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+									{
+										DeviceName: aws.String("/dev/xvda"),
+										Ebs: &ec2.EbsInstanceBlockDevice{
+											VolumeId: aws.String("vol-root"),
+										},
+									},
+									{
+										DeviceName: aws.String("/dev/sdf"),
+										Ebs: &ec2.EbsInstanceBlockDevice{
+											VolumeId: aws.String("vol-data"),
+										},
+									},
+									{
+										DeviceName: aws.String("/dev/sdg"),
+										Ebs: &ec2.EbsInstanceBlockDevice{
+											VolumeId: aws.String("vol-scratch"),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}, true)
+		},
+	).Return(nil)
+	mockEC2iface.EXPECT().CreateSnapshotsWithContext(
+		gomock.Any(),
+		&ec2.CreateSnapshotsInput{
+			Description: aws.String(
+				"Created by ec2_snapshot for testing1.bak.20160315120000 from i-1234abc",
+			),
+			InstanceSpecification: &ec2.InstanceSpecification{
+				InstanceId:           aws.String("i-1234abc"),
+				ExcludeBootVolume:    aws.Bool(true),
+				ExcludeDataVolumeIds: aws.StringSlice([]string{"vol-scratch"}),
+			},
+			TagSpecifications: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String(tagCreatedAt),
+							Value: aws.String("2016-03-15T12:00:00Z"),
+						},
+						{
+							Key:   aws.String(tagExpiresAt),
+							Value: aws.String("2016-03-22T12:00:00Z"),
+						},
+						{
+							Key:   aws.String(tagManagedBy),
+							Value: aws.String(managedByValue),
+						},
+						{
+							Key:   aws.String(tagPolicy),
+							Value: aws.String("testing1.bak"),
+						},
+						{
+							Key:   aws.String(tagSet),
+							Value: aws.String("testing1.bak.20160315120000"),
+						},
+						{
+							Key:   aws.String(tagSourceInstance),
+							Value: aws.String("i-1234abc"),
+						},
+					},
+				},
+			},
+		},
+	).Return(
+		&ec2.CreateSnapshotsOutput{
+			Snapshots: []*ec2.SnapshotInfo{
+				{
+					SnapshotId: aws.String("snap-1"),
+					VolumeId:   aws.String("vol-data"),
+				},
+			},
+		},
+		nil,
+	)
+	mockEC2iface.EXPECT().CreateTagsWithContext(
+		gomock.Any(),
+		&ec2.CreateTagsInput{
+			Resources: ids,
+			Tags: []*ec2.Tag{
+				{Key: aws.String(tagDevice), Value: aws.String("/dev/sdf")},
+			},
+		},
+	).Return(
+		&ec2.CreateTagsOutput{},
+		nil,
+	)
+	var describe = &ec2.DescribeSnapshotsInput{
+		OwnerIds:    aws.StringSlice([]string{"self"}),
+		SnapshotIds: ids,
+	}
+	gomock.InOrder(
+		expectSnapshotPages(
+			mockEC2iface,
+			describe,
+			&ec2.DescribeSnapshotsOutput{
+				Snapshots: []*ec2.Snapshot{
+					{
+						SnapshotId: aws.String("snap-1"),
+						State:      aws.String(ec2.SnapshotStatePending),
+					},
+				},
+			},
+			nil,
+		),
+		expectSnapshotPages(
+			mockEC2iface,
+			describe,
+			&ec2.DescribeSnapshotsOutput{
+				Snapshots: []*ec2.Snapshot{
+					{
+						SnapshotId: aws.String("snap-1"),
+						State:      aws.String(ec2.SnapshotStateCompleted),
+					},
+				},
+			},
+			nil,
+		),
+	)
+	// the new set is never pruned, the expired one is
+	expectSnapshotPages(
+		mockEC2iface,
+		s.describeSnapshotSetsInput(),
+		&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{
+				getSetSnapshot("snap-1", "testing1.bak", "testing1.bak.20160315120000"),
+				getSetSnapshot("snap-2", "testing1.bak", "testing1.bak.20150101000000"),
+				getSetSnapshot("snap-3", "testing1.bak", "testing1.bak.20150101000000"),
+			},
+		},
+		nil,
+	)
+	for _, id := range []string{"snap-2", "snap-3"} {
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		)
+	}
+
+	result, err := s.createSnapshotSet(context.Background(), "i-1234abc", now)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if result != "testing1.bak.20160315120000" {
+		t.Errorf("Expected testing1.bak.20160315120000 got %s", result)
+	}
+}
+
+func TestWaitForSnapshotsFailed(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:          mockEC2iface,
+		imageName:    "testing1.bak.20160315120000",
+		waitTimeout:  time.Second,
+		pollInterval: time.Millisecond,
+	}
+
+	// one failed snapshot discards the whole set
+	expectSnapshotPages(
+		mockEC2iface,
+		gomock.Any(),
+		&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("snap-1"),
+					State:      aws.String(ec2.SnapshotStateCompleted),
+				},
+				{
+					SnapshotId:   aws.String("snap-2"),
+					State:        aws.String(ec2.SnapshotStateError),
+					StateMessage: aws.String("volume went away"),
+				},
+			},
+		},
+		nil,
+	)
+	for _, id := range []string{"snap-1", "snap-2"} {
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		)
+	}
+	if err := s.waitForSnapshots(
+		context.Background(),
+		[]string{"snap-1", "snap-2"},
+	); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+
+	// other errors are not retried
+	expectSnapshotPages(
+		mockEC2iface,
+		gomock.Any(),
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if err := s.waitForSnapshots(
+		context.Background(),
+		[]string{"snap-1"},
+	); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestSnapshotSetCandidates(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak",
+		keepLast:                  1,
+	}
+
+	expectSnapshotPages(
+		mockEC2iface,
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds: aws.StringSlice([]string{"self"}),
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("tag:" + tagPolicy),
+					Values: aws.StringSlice([]string{"testing1.bak"}),
+				},
+				{
+					Name:   aws.String("tag-key"),
+					Values: aws.StringSlice([]string{tagSet}),
+				},
+			},
+		},
+		&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{
+				getSetSnapshot("snap-1", "testing1.bak", "testing1.bak.20150101000000"),
+				getSetSnapshot("snap-2", "testing1.bak", "testing1.bak.20160101000000"),
+				getSetSnapshot("snap-3", "testing1.bak", "testing1.bak.20150101000000"),
+				getSetSnapshot("snap-4", "testing2.bak", "testing2.bak.20160101000000"),
+				getSetSnapshot("snap-5", "testing1.bak", "testing1.bak"),
+			},
+		},
+		nil,
+	)
+	sets, kept, err := s.snapshotSetCandidates(context.Background())
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 {
+		t.Errorf("Expected 1 error but got %v", err)
+	}
+	var (
+		names  = []string{}
+		counts = []int{}
+	)
+	for _, set := range sets {
+		names = append(names, set.id)
+		counts = append(counts, len(set.snapshots))
+	}
+	var expectNames = []string{
+		"testing1.bak.20160101000000",
+		"testing1.bak.20150101000000",
+	}
+	if !reflect.DeepEqual(names, expectNames) {
+		t.Errorf("Expected %v got %v", expectNames, names)
+	}
+	if !reflect.DeepEqual(counts, []int{1, 2}) {
+		t.Errorf("Expected [1 2] got %v", counts)
+	}
+	var expectKept = []string{"testing1.bak.20160101000000"}
+	if result := keptIDs(kept); !reflect.DeepEqual(result, expectKept) {
+		t.Errorf("Expected %v got %v", expectKept, result)
+	}
+}
+
+func TestSetState(t *testing.T) {
+	var (
+		done    = getSetSnapshot("snap-1", "testing1.bak", "testing1.bak.20160101000000")
+		pending = &ec2.Snapshot{
+			SnapshotId: aws.String("snap-2"),
+			State:      aws.String(ec2.SnapshotStatePending),
+		}
+	)
+	if state := setState([]*ec2.Snapshot{done, done}); state != ec2.SnapshotStateCompleted {
+		t.Errorf("Expected completed got %s", state)
+	}
+	if state := setState([]*ec2.Snapshot{done, pending}); state != ec2.SnapshotStatePending {
+		t.Errorf("Expected pending got %s", state)
+	}
+}
+
+func TestPlanSnapshotSet(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		region:                    "us-east-1",
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.20160315120000",
+		keepLast:                  2,
+		mode:                      ModeSnapshots,
+	}
+
+	expectSnapshotPages(
+		mockEC2iface,
+		s.describeSnapshotSetsInput(),
+		&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{
+				getSetSnapshot("snap-1", "testing1.bak", "testing1.bak.20150101000000"),
+				getSetSnapshot("snap-2", "testing1.bak", "testing1.bak.20160101000000"),
+			},
+		},
+		nil,
+	)
+	var expect = []PlanItem{
+		{
+			Action:     ActionCreate,
+			Resource:   ResourceSnapshotSet,
+			Name:       "testing1.bak.20160315120000",
+			InstanceID: "i-1234abc",
+			Region:     "us-east-1",
+		},
+		{
+			Action:   ActionKeep,
+			Resource: ResourceSnapshotSet,
+			Name:     "testing1.bak.20160101000000",
+			Region:   "us-east-1",
+			Reason:   "one of the 2 newest",
+		},
+		{
+			Action:   ActionDelete,
+			Resource: ResourceSnapshotSet,
+			Name:     "testing1.bak.20150101000000",
+			Region:   "us-east-1",
+		},
+		{
+			Action:   ActionDelete,
+			Resource: ResourceSnapshot,
+			ID:       "snap-1",
+			Region:   "us-east-1",
+			Reason:   "belongs to testing1.bak.20150101000000",
+		},
+	}
+	result, err := s.planSnapshotSet(context.Background(), "i-1234abc")
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %+v got %+v", expect, result)
+	}
+}
@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// shareImage lets every account in shareAccounts launch image and create
+// volumes from its snapshots.
+func (s *Client) shareImage(ctx context.Context, image *ec2.Image) error {
+	return s.modifyPermissions(ctx, image, ec2.OperationTypeAdd)
+}
+
+// unshareImage revokes what shareImage granted.  It is called before an
+// image is pruned so other accounts never hold permissions on a backup that
+// is about to disappear.
+func (s *Client) unshareImage(ctx context.Context, image *ec2.Image) error {
+	return s.modifyPermissions(ctx, image, ec2.OperationTypeRemove)
+}
+
+func (s *Client) modifyPermissions(
+	ctx context.Context,
+	image *ec2.Image,
+	operation string,
+) error {
+	if len(s.shareAccounts) == 0 {
+		return nil
+	}
+	var (
+		launch = &ec2.LaunchPermissionModifications{}
+		volume = &ec2.CreateVolumePermissionModifications{}
+	)
+	for _, account := range s.shareAccounts {
+		var (
+			l = &ec2.LaunchPermission{UserId: aws.String(account)}
+			v = &ec2.CreateVolumePermission{UserId: aws.String(account)}
+		)
+		if operation == ec2.OperationTypeAdd {
+			launch.Add = append(launch.Add, l)
+			volume.Add = append(volume.Add, v)
+		} else {
+			launch.Remove = append(launch.Remove, l)
+			volume.Remove = append(volume.Remove, v)
+		}
+	}
+	_, err := s.svc.ModifyImageAttributeWithContext(
+		ctx,
+		&ec2.ModifyImageAttributeInput{
+			ImageId:          image.ImageId,
+			Attribute:        aws.String(ec2.ImageAttributeNameLaunchPermission),
+			LaunchPermission: launch,
+			DryRun:           aws.Bool(false),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"Failed to %s launch permission on %s b/c of %s",
+			operation,
+			*image.ImageId,
+			err.Error(),
+		)
+	}
+	for _, id := range snapshotIDs(image) {
+		_, err := s.svc.ModifySnapshotAttributeWithContext(
+			ctx,
+			&ec2.ModifySnapshotAttributeInput{
+				SnapshotId: aws.String(id),
+				Attribute: aws.String(
+					ec2.SnapshotAttributeNameCreateVolumePermission,
+				),
+				CreateVolumePermission: volume,
+				DryRun:                 aws.Bool(false),
+			},
+		)
+		if err != nil {
+			return fmt.Errorf(
+				"Failed to %s create volume permission on %s b/c of %s",
+				operation,
+				id,
+				err.Error(),
+			)
+		}
+	}
+	return nil
+}
+
+// ValidateAccountIDs reports the first entry that is not an AWS account ID.
+func ValidateAccountIDs(accounts []string) error {
+	for _, account := range accounts {
+		if !accountIDPattern.MatchString(account) {
+			return fmt.Errorf("%q is not a 12 digit AWS account ID", account)
+		}
+	}
+	return nil
+}
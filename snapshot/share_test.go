@@ -0,0 +1,259 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestShareImage(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:           mockEC2iface,
+		shareAccounts: []string{"111111111111", "222222222222"},
+	}
+
+	var image = &ec2.Image{
+		ImageId: aws.String("ami-123456a"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1")},
+			},
+			{
+				DeviceName: aws.String("/dev/xvdb"),
+				Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-2")},
+			},
+		},
+	}
+
+	mockEC2iface.EXPECT().ModifyImageAttributeWithContext(
+		gomock.Any(),
+		&ec2.ModifyImageAttributeInput{
+			ImageId:   aws.String("ami-123456a"),
+			Attribute: aws.String("launchPermission"),
+			LaunchPermission: &ec2.LaunchPermissionModifications{
+				Add: []*ec2.LaunchPermission{
+					{UserId: aws.String("111111111111")},
+					{UserId: aws.String("222222222222")},
+				},
+			},
+			DryRun: aws.Bool(false),
+		},
+	).Return(
+		&ec2.ModifyImageAttributeOutput{},
+		nil,
+	)
+	for _, id := range []string{"snap-1", "snap-2"} {
+		mockEC2iface.EXPECT().ModifySnapshotAttributeWithContext(
+			gomock.Any(),
+			&ec2.ModifySnapshotAttributeInput{
+				SnapshotId: aws.String(id),
+				Attribute:  aws.String("createVolumePermission"),
+				CreateVolumePermission: &ec2.CreateVolumePermissionModifications{
+					Add: []*ec2.CreateVolumePermission{
+						{UserId: aws.String("111111111111")},
+						{UserId: aws.String("222222222222")},
+					},
+				},
+				DryRun: aws.Bool(false),
+			},
+		).Return(
+			&ec2.ModifySnapshotAttributeOutput{},
+			nil,
+		)
+	}
+	if err := s.shareImage(context.Background(), image); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+
+	mockEC2iface.EXPECT().ModifyImageAttributeWithContext(gomock.Any(), gomock.Any()).Return(
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if err := s.unshareImage(context.Background(), image); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+
+	// nothing is called without accounts to share with
+	if err := (&Client{svc: mockEC2iface}).shareImage(context.Background(), image); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+}
+
+func TestRemoveOldImageRevokesSharing(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+		filter:                    filters,
+		shareAccounts:             []string{"111111111111"},
+	}
+
+	var image = &ec2.Image{
+		ImageId: aws.String("ami-123456a"),
+		Name:    aws.String("testing1.bak.20160102030405"),
+		Tags:    getBackupTags("testing1.bak"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1")},
+			},
+		},
+	}
+
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(filters, "testing1.bak"),
+		&ec2.DescribeImagesOutput{Images: []*ec2.Image{image}},
+		nil,
+	)
+	gomock.InOrder(
+		mockEC2iface.EXPECT().ModifyImageAttributeWithContext(
+			gomock.Any(),
+			&ec2.ModifyImageAttributeInput{
+				ImageId:   aws.String("ami-123456a"),
+				Attribute: aws.String("launchPermission"),
+				LaunchPermission: &ec2.LaunchPermissionModifications{
+					Remove: []*ec2.LaunchPermission{
+						{UserId: aws.String("111111111111")},
+					},
+				},
+				DryRun: aws.Bool(false),
+			},
+		).Return(
+			&ec2.ModifyImageAttributeOutput{},
+			nil,
+		),
+		mockEC2iface.EXPECT().ModifySnapshotAttributeWithContext(
+			gomock.Any(),
+			&ec2.ModifySnapshotAttributeInput{
+				SnapshotId: aws.String("snap-1"),
+				Attribute:  aws.String("createVolumePermission"),
+				CreateVolumePermission: &ec2.CreateVolumePermissionModifications{
+					Remove: []*ec2.CreateVolumePermission{
+						{UserId: aws.String("111111111111")},
+					},
+				},
+				DryRun: aws.Bool(false),
+			},
+		).Return(
+			&ec2.ModifySnapshotAttributeOutput{},
+			nil,
+		),
+		mockEC2iface.EXPECT().DeregisterImageWithContext(
+			gomock.Any(),
+			&ec2.DeregisterImageInput{
+				ImageId: aws.String("ami-123456a"),
+				DryRun:  aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeregisterImageOutput{},
+			nil,
+		),
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String("snap-1"),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		),
+	)
+	if err := s.removeOldImage(context.Background(), ""); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+}
+
+func TestValidateAccountIDs(t *testing.T) {
+	if err := ValidateAccountIDs([]string{"123456789012"}); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	for _, account := range []string{"12345678901", "1234567890123", "abcdefghijkl", ""} {
+		if err := ValidateAccountIDs([]string{account}); err == nil {
+			t.Errorf("Expected an error for %q but got nil", account)
+		}
+	}
+}
+
+func TestCreateImageShareFails(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+		waitTimeout:               time.Second,
+		pollInterval:              time.Millisecond,
+		shareAccounts:             []string{"111111111111"},
+		hooks:                     Hooks{Post: Hook{Command: "exit 1"}},
+	}
+
+	var (
+		params = &ec2.CreateImageInput{
+			Name:       aws.String("testing1.bak.1257894000"),
+			InstanceId: aws.String("i-1234abc"),
+		}
+		image = &ec2.Image{
+			ImageId:      aws.String("ami-123456d"),
+			Name:         aws.String("testing1.bak.1257894000"),
+			State:        aws.String(ec2.ImageStateAvailable),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: aws.String(time.Now().Format(time.RFC3339)),
+		}
+	)
+
+	gomock.InOrder(
+		mockEC2iface.EXPECT().CreateImageWithContext(gomock.Any(), params).Return(
+			&ec2.CreateImageOutput{ImageId: aws.String("ami-123456d")},
+			nil,
+		),
+		mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), gomock.Any()).Return(
+			&ec2.DescribeImagesOutput{Images: []*ec2.Image{image}},
+			nil,
+		),
+		mockEC2iface.EXPECT().ModifyImageAttributeWithContext(gomock.Any(), gomock.Any()).Return(
+			nil,
+			errors.New("Some error blah blah"),
+		),
+		// old backups are still pruned
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(nil, "testing1.bak"),
+			&ec2.DescribeImagesOutput{Images: []*ec2.Image{image}},
+			nil,
+		),
+	)
+	id, err := s.createImage(context.Background(), params)
+	if id != "ami-123456d" {
+		t.Errorf("Expected ami-123456d got %q", id)
+	}
+	// the post hook failure is kept alongside the share failure
+	if errs, ok := err.(MultiError); !ok || len(errs) != 2 {
+		t.Errorf("Expected 2 errors got %v", err)
+	}
+}
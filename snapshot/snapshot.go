@@ -0,0 +1,565 @@
+// Package snapshot backs up EC2 instances as AMIs, or as sets of EBS
+// snapshots, and prunes old backups under a retention policy.  A Client is
+// built with New from an EC2 client and Options; Create takes a backup,
+// Prune removes expired ones and List reports what exists and why it is
+// kept.
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// DeleteError reports a backup, or one of its snapshots, that could not be
+// pruned.
+type DeleteError struct {
+	ImageName string
+	Msg       string
+}
+
+// CreateError reports a backup that could not be created, copied or shared.
+type CreateError struct {
+	ImageName string
+	Msg       string
+}
+
+// MultiError collects the failures of an operation that carries on past
+// individual errors, such as pruning several images.
+type MultiError []error
+
+// Client takes and prunes the backups of one image name.  It is not safe
+// for concurrent use.
+type Client struct {
+	svc                       ec2iface.EC2API
+	region                    string
+	imageNameWithoutTimestamp string
+	imageName                 string
+	newImageID                string
+	timeToSave                int64
+	keepLast                  int
+	schedule                  retentionRule
+	tags                      map[string]string
+	filter                    []*ec2.Filter
+	waitTimeout               time.Duration
+	pollInterval              time.Duration
+	kmsKeyID                  string
+	copies                    []*Client
+	shareAccounts             []string
+	account                   string
+	noReboot                  bool
+	hooks                     Hooks
+	mode                      string
+	excludeBootVolume         bool
+	excludeDevices            []string
+}
+
+func (e *DeleteError) Error() string {
+	return fmt.Sprintf(
+		"Image delete failed for image %s with \"%s\"",
+		e.ImageName,
+		e.Msg,
+	)
+}
+
+func (e *CreateError) Error() string {
+	return fmt.Sprintf(
+		"Image create failed for image %s with \"%s\"",
+		e.ImageName,
+		e.Msg,
+	)
+}
+
+func (m MultiError) Error() string {
+	var msgs = []string{}
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) == 1 {
+		return msgs[0]
+	}
+	return fmt.Sprintf("%d errors: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// errorOrNil returns m as an error, or nil when nothing failed, so that an
+// empty MultiError never reaches a caller as a non-nil error.
+func (m MultiError) errorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (s *Client) createImage(
+	ctx context.Context,
+	imageMeta *ec2.CreateImageInput,
+) (string, error) {
+	var (
+		outputData *ec2.CreateImageOutput
+		errs       MultiError
+	)
+	err := s.quiesce(ctx, aws.StringValue(imageMeta.InstanceId), func() error {
+		var err error
+		outputData, err = s.svc.CreateImageWithContext(ctx, imageMeta)
+		return err
+	})
+	if outputData == nil {
+		return "", &CreateError{
+			*imageMeta.Name,
+			fmt.Sprintf("Failed to create image b/c of %s", err.Error()),
+		}
+	}
+	if err != nil {
+		errs = append(errs, &CreateError{*imageMeta.Name, err.Error()})
+	}
+	s.newImageID = *outputData.ImageId
+	image, err := s.waitForImage(ctx, *outputData.ImageId)
+	if err != nil {
+		return *outputData.ImageId, append(errs, err)
+	}
+	if err := s.shareImage(ctx, image); err != nil {
+		errs = append(errs, &CreateError{*imageMeta.Name, err.Error()})
+	}
+	if err := s.removeOldImage(
+		ctx,
+		*outputData.ImageId,
+	); err != nil {
+		errs = append(errs, &DeleteError{*imageMeta.Name, err.Error()})
+	}
+	for _, c := range s.copies {
+		if _, err := c.copyImage(ctx, s.region, image); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return *outputData.ImageId, errs.errorOrNil()
+}
+
+// removeOldImage deletes every backup the retention policy does not keep.
+// A failure on one image does not stop the others from being pruned; all
+// failures are returned together as a MultiError.  Once ctx is done no
+// further image is started.
+func (s *Client) removeOldImage(ctx context.Context, newImageID string) error {
+	images, kept, err := s.pruneCandidates(ctx)
+	if images == nil {
+		return err
+	}
+	var errs MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, b := range images {
+		image := b.image
+		if _, ok := kept[b.id]; ok || s.newImageID == b.id {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, &DeleteError{
+				s.imageName,
+				fmt.Sprintf("Stopped pruning b/c of %s", err.Error()),
+			})
+			break
+		}
+		if err := s.deleteImage(ctx, image); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// deleteImage revokes sharing on image, deregisters it and deletes its
+// snapshots.  Once started it runs to completion even if ctx is done, so
+// that an image is never left deregistered with its snapshots in place.
+func (s *Client) deleteImage(ctx context.Context, image *ec2.Image) error {
+	ctx, cancel := withoutCancel(ctx)
+	defer cancel()
+	if err := s.unshareImage(ctx, image); err != nil {
+		return &DeleteError{*image.Name, err.Error()}
+	}
+	_, err := s.svc.DeregisterImageWithContext(
+		ctx,
+		&ec2.DeregisterImageInput{
+			ImageId: image.ImageId,
+			DryRun:  aws.Bool(false),
+		},
+	)
+	if err != nil {
+		return &DeleteError{
+			*image.Name,
+			fmt.Sprintf("Failed to deregister image b/c of %s", err.Error()),
+		}
+	}
+	if err := s.deleteImageSnapshots(ctx, image); err != nil {
+		return &DeleteError{
+			*image.Name,
+			fmt.Sprintf(
+				"Failed to delete snapshot for image b/c of %s",
+				err.Error(),
+			),
+		}
+	}
+	return nil
+}
+
+// pruneCandidates returns the backups of this image name, newest first,
+// together with the reason each one kept by the retention policy survives.
+// Extra images, such as one that is about to be created, take part in the
+// ranking as if they already existed.  Images whose backup time cannot be
+// worked out are left out, never pruned, and reported in a MultiError
+// alongside the other results; images is only nil when listing failed.
+func (s *Client) pruneCandidates(
+	ctx context.Context,
+	extra ...backup,
+) ([]backup, map[string]string, error) {
+	var (
+		images = append([]backup{}, extra...)
+		errs   MultiError
+	)
+	err := s.svc.DescribeImagesPagesWithContext(
+		ctx,
+		s.describeBackupsInput(),
+		func(page *ec2.DescribeImagesOutput, lastPage bool) bool {
+			for _, image := range page.Images {
+				if !s.ownsImage(image) {
+					continue
+				}
+				imageCreationTime, timeFormatError := backupTime(image)
+				if timeFormatError != nil {
+					errs = append(errs, &DeleteError{
+						*image.ImageId,
+						fmt.Sprintf(
+							"Skipped image with unknown backup time b/c of %s",
+							timeFormatError.Error(),
+						),
+					})
+					continue
+				}
+				images = append(images, backup{
+					id:      *image.ImageId,
+					created: imageCreationTime,
+					image:   image,
+				})
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, nil, &DeleteError{
+			s.imageName,
+			fmt.Sprintf("Failed to describe images with error %s", err.Error()),
+		}
+	}
+	sort.Sort(newestFirst(images))
+	return images, s.retentionPolicy().keep(images, time.Now()), errs.errorOrNil()
+}
+
+// describeBackupsInput asks for this account's images named like backups
+// of imageNameWithoutTimestamp, on top of the configured filters.  The name
+// filter only narrows what AWS returns; ownsImage still decides which of the
+// images really belong to this backup.
+func (s *Client) describeBackupsInput() *ec2.DescribeImagesInput {
+	return &ec2.DescribeImagesInput{
+		Owners: []*string{aws.String("self")},
+		Filters: append(
+			append([]*ec2.Filter{}, s.filter...),
+			&ec2.Filter{
+				Name: aws.String("name"),
+				Values: []*string{
+					aws.String(s.imageNameWithoutTimestamp + ".*"),
+				},
+			},
+		),
+	}
+}
+
+// ownsImage reports whether image is a backup this tool created for
+// imageNameWithoutTimestamp.  Tagged images are matched on their policy tag
+// alone.  Untagged images, taken before tagging was added, must be named
+// exactly <imageNameWithoutTimestamp>.<timestamp>, so that a backup named
+// "web" never prunes "web-prod" or "webhooks" images.
+func (s *Client) ownsImage(image *ec2.Image) bool {
+	if getTagValue(image.Tags, tagManagedBy) == managedByValue {
+		return getTagValue(image.Tags, tagPolicy) == s.imageNameWithoutTimestamp
+	}
+	if image.Name == nil {
+		return false
+	}
+	prefix, _, ok := splitTimestampedName(*image.Name)
+	return ok && prefix == s.imageNameWithoutTimestamp
+}
+
+// retentionPolicy always honours time-to-save, adding keep-last and the
+// configured schedule when set.
+func (s *Client) retentionPolicy() retentionPolicy {
+	var policy = retentionPolicy{maxAgeRule(s.timeToSave)}
+	if s.keepLast > 0 {
+		policy = append(policy, keepLastRule(s.keepLast))
+	}
+	if s.schedule != nil {
+		policy = append(policy, s.schedule)
+	}
+	return policy
+}
+
+// deleteImageSnapshots deletes every snapshot backing image, as recorded in
+// the block device mappings returned by DescribeImages.  Legacy images whose
+// mappings carry no snapshot IDs fall back to deleteSnapshotByDescription.
+// The image must already have been deregistered.
+func (s *Client) deleteImageSnapshots(
+	ctx context.Context,
+	image *ec2.Image,
+) error {
+	var (
+		ids      = snapshotIDs(image)
+		firstErr error
+	)
+	if len(ids) == 0 {
+		return s.deleteSnapshotByDescription(ctx, *image.ImageId)
+	}
+	for _, id := range ids {
+		_, err := s.svc.DeleteSnapshotWithContext(
+			ctx,
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		)
+		if err != nil && firstErr == nil {
+			firstErr = &DeleteError{id, err.Error()}
+		}
+	}
+	return firstErr
+}
+
+// snapshotIDs returns the IDs of the EBS snapshots in image's block device
+// mappings.
+func snapshotIDs(image *ec2.Image) []string {
+	var result = []string{}
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			result = append(result, *mapping.Ebs.SnapshotId)
+		}
+	}
+	return result
+}
+
+// imageSize returns the size in GiB of the EBS volumes image was taken of.
+func imageSize(image *ec2.Image) int64 {
+	var result int64
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil {
+			result += aws.Int64Value(mapping.Ebs.VolumeSize)
+		}
+	}
+	return result
+}
+
+func (s *Client) deleteSnapshotByDescription(
+	ctx context.Context,
+	imageID string,
+) error {
+	snapshots, err := s.findSnapshotsByDescription(ctx, imageID)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		_, err = s.svc.DeleteSnapshotWithContext(
+			ctx,
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: snapshot.SnapshotId,
+				DryRun:     aws.Bool(false),
+			},
+		)
+		if err != nil {
+			return &DeleteError{*snapshot.Description, err.Error()}
+		}
+	}
+	return nil
+}
+
+// findSnapshotsByDescription returns every snapshot whose description
+// mentions imageID, which is how CreateImage labels the snapshots it takes.
+func (s *Client) findSnapshotsByDescription(
+	ctx context.Context,
+	imageID string,
+) ([]*ec2.Snapshot, error) {
+	var result []*ec2.Snapshot
+	err := s.svc.DescribeSnapshotsPagesWithContext(
+		ctx,
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds: []*string{aws.String("self")},
+			Filters: append(
+				append([]*ec2.Filter{}, s.filter...),
+				&ec2.Filter{
+					Name:   aws.String("description"),
+					Values: []*string{aws.String("*" + imageID + "*")},
+				},
+			),
+		},
+		func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			for _, snapshot := range page.Snapshots {
+				if snapshot.Description != nil && strings.Contains(
+					*snapshot.Description,
+					imageID,
+				) {
+					result = append(result, snapshot)
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, &DeleteError{
+			s.imageName,
+			fmt.Sprintf(
+				"Could not get snapshot list for deletion with msg %s",
+				err.Error(),
+			),
+		}
+	}
+	return result, nil
+}
+
+func createNameWithTimestamp(s string) string {
+	return fmt.Sprintf(
+		"%s.%s",
+		s,
+		time.Now().Format(timestampFormat),
+	)
+}
+
+// ParseTagSelectors turns "Backup=daily,Env=prod" into tag filters.  A key
+// without a value matches any instance carrying that tag.
+func ParseTagSelectors(s string) ([]*ec2.Filter, error) {
+	var result = []*ec2.Filter{}
+	for _, selector := range strings.Split(s, ",") {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+		parts := strings.SplitN(selector, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			return nil, fmt.Errorf("Tag selector %q is missing a key", selector)
+		}
+		if len(parts) == 1 {
+			result = append(
+				result,
+				&ec2.Filter{
+					Name:   aws.String("tag-key"),
+					Values: []*string{aws.String(key)},
+				},
+			)
+			continue
+		}
+		result = append(
+			result,
+			&ec2.Filter{
+				Name:   aws.String("tag:" + key),
+				Values: []*string{aws.String(strings.TrimSpace(parts[1]))},
+			},
+		)
+	}
+	return result, nil
+}
+
+// FindInstances returns every instance matching filters that can still be
+// imaged, i.e. is not terminated or on its way there.
+func FindInstances(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	filters []*ec2.Filter,
+) ([]*ec2.Instance, error) {
+	var result = []*ec2.Instance{}
+	err := svc.DescribeInstancesPagesWithContext(
+		ctx,
+		&ec2.DescribeInstancesInput{Filters: filters},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					if instance.State != nil && instance.State.Name != nil {
+						switch *instance.State.Name {
+						case "terminated", "shutting-down":
+							continue
+						}
+					}
+					result = append(result, instance)
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe instances with error %s", err.Error())
+	}
+	return result, nil
+}
+
+// InstanceImageNames derives an image name for each instance from its Name
+// tag, falling back to the instance ID.  Instances sharing a Name tag get
+// their ID appended so their backups are never pruned against each other.
+func InstanceImageNames(
+	prefix string,
+	instances []*ec2.Instance,
+) map[string]string {
+	var tags = map[string][]*ec2.Tag{}
+	for _, instance := range instances {
+		tags[*instance.InstanceId] = instance.Tags
+	}
+	return backupNames(prefix, tags)
+}
+
+// backupNames names the backup of each resource, keyed by ID, after its
+// Name tag as InstanceImageNames describes.
+func backupNames(prefix string, tags map[string][]*ec2.Tag) map[string]string {
+	var (
+		names  = map[string]string{}
+		counts = map[string]int{}
+	)
+	for id := range tags {
+		names[id] = sanitizeImageName(getTagValue(tags[id], "Name"))
+		counts[names[id]]++
+	}
+	for id, name := range names {
+		switch {
+		case name == "":
+			names[id] = id
+		case counts[name] > 1:
+			names[id] = fmt.Sprintf("%s-%s", name, id)
+		}
+		if prefix != "" {
+			names[id] = fmt.Sprintf("%s.%s", prefix, names[id])
+		}
+	}
+	return names
+}
+
+func getTagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+// sanitizeImageName replaces characters AWS does not allow in AMI names.
+func sanitizeImageName(s string) string {
+	return strings.Map(
+		func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			case strings.ContainsRune("()[] ./-'@_", r):
+				return r
+			}
+			return '-'
+		},
+		strings.TrimSpace(s),
+	)
+}
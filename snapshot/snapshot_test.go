@@ -0,0 +1,1765 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PermissionData/ec2_snapshot/mock_ec2iface"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func getMocks(t *testing.T) (*mock_ec2iface.MockEC2API, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+	return mock_ec2iface.NewMockEC2API(ctrl), ctrl
+}
+
+// expectImagePages expects one DescribeImagesPagesWithContext call with input
+// and hands out to its page callback as the only page.
+func expectImagePages(
+	m *mock_ec2iface.MockEC2API,
+	input interface{},
+	out *ec2.DescribeImagesOutput,
+	err error,
+) *gomock.Call {
+	return m.EXPECT().DescribeImagesPagesWithContext(
+		gomock.Any(),
+		input,
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeImagesInput,
+			fn func(*ec2.DescribeImagesOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			if out != nil {
+				fn(out, true)
+			}
+		},
+	).Return(err)
+}
+
+// expectSnapshotPages is expectImagePages for DescribeSnapshotsPagesWithContext.
+func expectSnapshotPages(
+	m *mock_ec2iface.MockEC2API,
+	input interface{},
+	out *ec2.DescribeSnapshotsOutput,
+	err error,
+) *gomock.Call {
+	return m.EXPECT().DescribeSnapshotsPagesWithContext(
+		gomock.Any(),
+		input,
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeSnapshotsInput,
+			fn func(*ec2.DescribeSnapshotsOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			if out != nil {
+				fn(out, true)
+			}
+		},
+	).Return(err)
+}
+
+// getBackupsInput returns the input used to list the backups named name.
+func getBackupsInput(filters []*ec2.Filter, name string) *ec2.DescribeImagesInput {
+	return &ec2.DescribeImagesInput{
+		Owners: aws.StringSlice([]string{"self"}),
+		Filters: append(append([]*ec2.Filter{}, filters...), &ec2.Filter{
+			Name:   aws.String("name"),
+			Values: aws.StringSlice([]string{name + ".*"}),
+		}),
+	}
+}
+
+// getSnapshotsByDescriptionInput returns the input used to find the
+// snapshots taken for imageID.
+func getSnapshotsByDescriptionInput(
+	filters []*ec2.Filter,
+	imageID string,
+) *ec2.DescribeSnapshotsInput {
+	return &ec2.DescribeSnapshotsInput{
+		OwnerIds: aws.StringSlice([]string{"self"}),
+		Filters: append(append([]*ec2.Filter{}, filters...), &ec2.Filter{
+			Name:   aws.String("description"),
+			Values: aws.StringSlice([]string{"*" + imageID + "*"}),
+		}),
+	}
+}
+
+// getBackupTags returns the ownership tags written on images created for
+// policy.
+func getBackupTags(policy string) []*ec2.Tag {
+	return []*ec2.Tag{
+		{Key: aws.String(tagManagedBy), Value: aws.String(managedByValue)},
+		{Key: aws.String(tagPolicy), Value: aws.String(policy)},
+	}
+}
+
+func TestRemoveOldIMage(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var getTimeSecondsBeforeNowAsString = func(s int64) *string {
+		t := time.Now().Add(-time.Duration(s) * time.Second).Format(time.RFC3339)
+		return &t
+	}
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var happyPathTests = []struct {
+		s            *Client
+		newImageID   string
+		awsImages    []*ec2.Image
+		deletes      []int
+		AWSSnapshots []*ec2.Snapshot
+	}{
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing1.bak",
+				imageName:                 "testing1.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			newImageID: "ami-123456d",
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604801),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2),
+				},
+				{
+					ImageId:      aws.String("ami-123456e"),
+					Name:         aws.String("testing1.bak.3898349383"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(1604802),
+				},
+			},
+			deletes: []int{1, 4},
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456b",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456e",
+					),
+				},
+			},
+		},
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing1.bak",
+				imageName:                 "testing1.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			newImageID: "ami-123456d",
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604802),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604801),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(900000),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(999999999),
+				},
+				{
+					ImageId:      aws.String("ami-123456e"),
+					Name:         aws.String("testing1.bak.3898349383"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(1000000),
+				},
+			},
+			deletes: []int{0, 1, 2, 3, 4},
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456b",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456e",
+					),
+				},
+				{
+					SnapshotId: aws.String("test3"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456a",
+					),
+				},
+				{
+					SnapshotId: aws.String("test4"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456c",
+					),
+				},
+				{
+					SnapshotId: aws.String("test5"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456d",
+					),
+				},
+			},
+		},
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing1.bak",
+				imageName:                 "testing1.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			newImageID: "ami-123456d",
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing2.bak.438208309884"),
+					Tags:         getBackupTags("testing2.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604801),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2),
+				},
+				{
+					ImageId:      aws.String("ami-123456e"),
+					Name:         aws.String("testing3.bak.3898349383"),
+					Tags:         getBackupTags("testing3.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(1604802),
+				},
+			},
+			deletes: []int{},
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456b",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456e",
+					),
+				},
+			},
+		},
+	}
+
+	for _, test := range happyPathTests {
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			&ec2.DescribeImagesOutput{Images: test.awsImages},
+			nil,
+		)
+		for index, i := range test.deletes {
+			mockEC2iface.EXPECT().DeregisterImageWithContext(
+				gomock.Any(),
+				&ec2.DeregisterImageInput{
+					ImageId: test.awsImages[i].ImageId,
+					DryRun:  aws.Bool(false),
+				},
+			).Return(
+				&ec2.DeregisterImageOutput{},
+				nil,
+			)
+			expectSnapshotPages(
+				mockEC2iface,
+				getSnapshotsByDescriptionInput(
+					filters,
+					*test.awsImages[i].ImageId,
+				),
+				&ec2.DescribeSnapshotsOutput{
+					Snapshots: test.AWSSnapshots,
+				},
+				nil,
+			)
+			mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+				gomock.Any(),
+				&ec2.DeleteSnapshotInput{
+					SnapshotId: test.AWSSnapshots[index].SnapshotId,
+					DryRun:     aws.Bool(false),
+				},
+			).Return(
+				&ec2.DeleteSnapshotOutput{},
+				nil,
+			)
+		}
+		err := test.s.removeOldImage(context.Background(), test.newImageID)
+		if err != nil {
+			t.Errorf("Expect 'nil' got %v", err)
+		}
+	}
+
+	var describeImagesErrorTest = []struct {
+		s          *Client
+		newImageID string
+	}{
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing1.bak",
+				imageName:                 "testing1.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			newImageID: "ami-123456d",
+		},
+	}
+
+	for _, test := range describeImagesErrorTest {
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			nil,
+			errors.New("Some error blah blah"),
+		)
+		err := test.s.removeOldImage(context.Background(), test.newImageID)
+		if err == nil {
+			t.Error("Expect an error but got nil")
+		}
+	}
+
+	var deregisterImageErrorTest = []struct {
+		s            *Client
+		newImageID   string
+		awsImages    []*ec2.Image
+		deletes      []int
+		AWSSnapshots []*ec2.Snapshot
+	}{
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing1.bak",
+				imageName:                 "testing1.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			newImageID: "ami-123456d",
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604801),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2),
+				},
+				{
+					ImageId:      aws.String("ami-123456e"),
+					Name:         aws.String("testing1.bak.3898349383"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(1604802),
+				},
+			},
+			deletes: []int{1, 4},
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456b",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456e",
+					),
+				},
+			},
+		},
+	}
+
+	for _, test := range deregisterImageErrorTest {
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			&ec2.DescribeImagesOutput{Images: test.awsImages},
+			nil,
+		)
+		for _, i := range test.deletes {
+			mockEC2iface.EXPECT().DeregisterImageWithContext(
+				gomock.Any(),
+				&ec2.DeregisterImageInput{
+					ImageId: test.awsImages[i].ImageId,
+					DryRun:  aws.Bool(false),
+				},
+			).Return(
+				nil,
+				errors.New("Another error blah blah"),
+			)
+		}
+		err := test.s.removeOldImage(context.Background(), test.newImageID)
+		if errs, ok := err.(MultiError); !ok || len(errs) != len(test.deletes) {
+			t.Errorf("Expected %d errors but got %v", len(test.deletes), err)
+		}
+	}
+
+	var deleteSnapshotByDescriptionErrorTests = []struct {
+		s            *Client
+		newImageID   string
+		awsImages    []*ec2.Image
+		deletes      []int
+		AWSSnapshots []*ec2.Snapshot
+	}{
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing1.bak",
+				imageName:                 "testing1.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			newImageID: "ami-123456d",
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604801),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(604799),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2),
+				},
+				{
+					ImageId:      aws.String("ami-123456e"),
+					Name:         aws.String("testing1.bak.3898349383"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(1604802),
+				},
+			},
+			deletes: []int{1, 4},
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456b",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This snapshot is taken from ami-123456e",
+					),
+				},
+			},
+		},
+	}
+
+	for _, test := range deleteSnapshotByDescriptionErrorTests {
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			&ec2.DescribeImagesOutput{Images: test.awsImages},
+			nil,
+		)
+		for index, i := range test.deletes {
+			mockEC2iface.EXPECT().DeregisterImageWithContext(
+				gomock.Any(),
+				&ec2.DeregisterImageInput{
+					ImageId: test.awsImages[i].ImageId,
+					DryRun:  aws.Bool(false),
+				},
+			).Return(
+				&ec2.DeregisterImageOutput{},
+				nil,
+			)
+			expectSnapshotPages(
+				mockEC2iface,
+				getSnapshotsByDescriptionInput(
+					filters,
+					*test.awsImages[i].ImageId,
+				),
+				&ec2.DescribeSnapshotsOutput{
+					Snapshots: test.AWSSnapshots,
+				},
+				nil,
+			)
+			mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+				gomock.Any(),
+				&ec2.DeleteSnapshotInput{
+					SnapshotId: test.AWSSnapshots[index].SnapshotId,
+					DryRun:     aws.Bool(false),
+				},
+			).Return(
+				nil,
+				errors.New("Some error blah blah blah"),
+			)
+		}
+		err := test.s.removeOldImage(context.Background(), test.newImageID)
+		if errs, ok := err.(MultiError); !ok || len(errs) != len(test.deletes) {
+			t.Errorf("Expected %d errors but got %v", len(test.deletes), err)
+		}
+	}
+}
+
+func TestRemoveOldImageBadCreationDate(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+	}
+
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(nil, "testing1.bak"),
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: aws.String("last tuesday"),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: aws.String("2015-06-07T00:00:00Z"),
+					BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+						{
+							DeviceName: aws.String("/dev/xvda"),
+							Ebs: &ec2.EbsBlockDevice{
+								SnapshotId: aws.String("snap-1"),
+							},
+						},
+					},
+				},
+			},
+		},
+		nil,
+	)
+	// the unparsable image is skipped, the other is still pruned
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456b"),
+			DryRun:  aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+		gomock.Any(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1"),
+			DryRun:     aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeleteSnapshotOutput{},
+		nil,
+	)
+
+	err := s.removeOldImage(context.Background(), "ami-123456c")
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 {
+		t.Errorf("Expected 1 error but got %v", err)
+	}
+}
+
+func TestRemoveOldImageKeepLast(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var getTimeSecondsBeforeNowAsString = func(s int64) *string {
+		t := time.Now().Add(-time.Duration(s) * time.Second).Format(time.RFC3339)
+		return &t
+	}
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var tests = []struct {
+		keepLast  int
+		awsImages []*ec2.Image
+		deletes   []int
+	}{
+		{
+			keepLast: 2,
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2000000),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(1000000),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(3000000),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(900000),
+				},
+			},
+			deletes: []int{0, 2},
+		},
+		{
+			keepLast: 2,
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2000000),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(200),
+				},
+				{
+					ImageId:      aws.String("ami-123456c"),
+					Name:         aws.String("testing1.bak.4284932088"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(100),
+				},
+				{
+					ImageId:      aws.String("ami-123456d"),
+					Name:         aws.String("testing1.bak.993948322"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(300),
+				},
+			},
+			deletes: []int{0},
+		},
+		{
+			keepLast: 5,
+			awsImages: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-123456a"),
+					Name:         aws.String("testing1.bak.848590424"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(2000000),
+				},
+				{
+					ImageId:      aws.String("ami-123456b"),
+					Name:         aws.String("testing1.bak.438208309884"),
+					Tags:         getBackupTags("testing1.bak"),
+					CreationDate: getTimeSecondsBeforeNowAsString(3000000),
+				},
+			},
+			deletes: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		s := &Client{
+			svc:                       mockEC2iface,
+			imageNameWithoutTimestamp: "testing1.bak",
+			imageName:                 "testing1.bak.1257894000",
+			timeToSave:                604800,
+			keepLast:                  test.keepLast,
+			filter:                    filters,
+		}
+		expectImagePages(
+			mockEC2iface,
+			getBackupsInput(filters, "testing1.bak"),
+			&ec2.DescribeImagesOutput{Images: test.awsImages},
+			nil,
+		)
+		for _, i := range test.deletes {
+			mockEC2iface.EXPECT().DeregisterImageWithContext(
+				gomock.Any(),
+				&ec2.DeregisterImageInput{
+					ImageId: test.awsImages[i].ImageId,
+					DryRun:  aws.Bool(false),
+				},
+			).Return(
+				&ec2.DeregisterImageOutput{},
+				nil,
+			)
+			expectSnapshotPages(
+				mockEC2iface,
+				getSnapshotsByDescriptionInput(
+					filters,
+					*test.awsImages[i].ImageId,
+				),
+				&ec2.DescribeSnapshotsOutput{},
+				nil,
+			)
+		}
+		err := s.removeOldImage(context.Background(), "")
+		if err != nil {
+			t.Errorf("Expect 'nil' got %v", err)
+		}
+	}
+}
+
+func TestRemoveOldImageBlockDeviceMappings(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var getTimeSecondsBeforeNowAsString = func(s int64) *string {
+		t := time.Now().Add(-time.Duration(s) * time.Second).Format(time.RFC3339)
+		return &t
+	}
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+		filter:                    filters,
+	}
+
+	var awsImages = []*ec2.Image{
+		{
+			ImageId:      aws.String("ami-123456a"),
+			Name:         aws.String("testing1.bak.848590424"),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: getTimeSecondsBeforeNowAsString(1000000),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1")},
+				},
+				{
+					DeviceName:  aws.String("/dev/xvdb"),
+					VirtualName: aws.String("ephemeral0"),
+				},
+				{
+					DeviceName: aws.String("/dev/xvdc"),
+					Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-2")},
+				},
+				{
+					DeviceName: aws.String("/dev/xvdd"),
+					Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-3")},
+				},
+			},
+		},
+	}
+
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(filters, "testing1.bak"),
+		&ec2.DescribeImagesOutput{Images: awsImages},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	for _, id := range []string{"snap-1", "snap-2", "snap-3"} {
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		)
+	}
+	if err := s.removeOldImage(context.Background(), ""); err != nil {
+		t.Errorf("Expect 'nil' got %v", err)
+	}
+
+	// a failed delete must not stop the remaining snapshots being deleted
+	expectImagePages(
+		mockEC2iface,
+		getBackupsInput(filters, "testing1.bak"),
+		&ec2.DescribeImagesOutput{Images: awsImages},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+		gomock.Any(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1"),
+			DryRun:     aws.Bool(false),
+		},
+	).Return(
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	for _, id := range []string{"snap-2", "snap-3"} {
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		)
+	}
+	if err := s.removeOldImage(context.Background(), ""); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestDeleteSnapshotByDescription(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var happyPathTests = []struct {
+		s                  *Client
+		imageIdToDelete    string
+		AWSSnapshots       []*ec2.Snapshot
+		snapshotIdToDelete *string
+	}{
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing123.bak",
+				imageName:                 "testing123.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			imageIdToDelete: "img-1323949t",
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This is snapshot taken from img-1323949t",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This is snapshot taken from img-fdafkdlkfj",
+					),
+				},
+			},
+			snapshotIdToDelete: aws.String("test1"),
+		},
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing123.bak",
+				imageName:                 "testing123.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			imageIdToDelete: "img-1323949t",
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This is snapshot taken from img-1323949f",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This is snapshot taken from img-1323949t",
+					),
+				},
+			},
+			snapshotIdToDelete: aws.String("test2"),
+		},
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing123.bak",
+				imageName:                 "testing123.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			imageIdToDelete: "img-1323949t",
+			AWSSnapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("test1"),
+					Description: aws.String(
+						"This is snapshot taken from img-fdjkfjkj",
+					),
+				},
+				{
+					SnapshotId: aws.String("test2"),
+					Description: aws.String(
+						"This is snapshot taken from img-fdfdabbvd",
+					),
+				},
+			},
+			snapshotIdToDelete: nil,
+		},
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing123.bak",
+				imageName:                 "testing123.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			imageIdToDelete:    "img-1323949t",
+			AWSSnapshots:       []*ec2.Snapshot{},
+			snapshotIdToDelete: nil,
+		},
+	}
+
+	for _, test := range happyPathTests {
+		expectSnapshotPages(
+			mockEC2iface,
+			getSnapshotsByDescriptionInput(filters, test.imageIdToDelete),
+			&ec2.DescribeSnapshotsOutput{
+				Snapshots: test.AWSSnapshots,
+			},
+			nil,
+		)
+		if test.snapshotIdToDelete != nil {
+			mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+				gomock.Any(),
+				&ec2.DeleteSnapshotInput{
+					SnapshotId: test.snapshotIdToDelete,
+					DryRun:     aws.Bool(false),
+				},
+			).Return(
+				&ec2.DeleteSnapshotOutput{},
+				nil,
+			)
+		}
+		err := test.s.deleteSnapshotByDescription(context.Background(), test.imageIdToDelete)
+		if err != nil {
+			t.Errorf("Expected nil but got error")
+		}
+	}
+
+	var validateNegativeTests = func(
+		err error,
+		expectedMsg string,
+		imageName string,
+	) {
+		if err == nil {
+			t.Errorf("Expected an error by got nil but got error")
+		}
+		e := DeleteError{
+			ImageName: imageName,
+			Msg:       expectedMsg,
+		}
+		if err.Error() != e.Error() {
+			t.Errorf(
+				"Expected error message of '%s' but got '%s'",
+				e.Error(),
+				err.Error(),
+			)
+		}
+	}
+
+	var describeSnapshotsErrorTests = []struct {
+		s               *Client
+		imageIdToDelete string
+		AWSSnapshots    []*ec2.Snapshot
+		awsErr          awserr.Error
+	}{
+		{
+			s: &Client{
+				svc:                       mockEC2iface,
+				imageNameWithoutTimestamp: "testing123.bak",
+				imageName:                 "testing123.bak.1257894000",
+				timeToSave:                604800,
+				filter:                    filters,
+			},
+			imageIdToDelete: "img-1323949t",
+			AWSSnapshots:    nil,
+			awsErr: awserr.New(
+				"01-01",
+				"Something when wrong",
+				nil,
+			),
+		},
+	}
+
+	for _, test := range describeSnapshotsErrorTests {
+		expectSnapshotPages(
+			mockEC2iface,
+			getSnapshotsByDescriptionInput(filters, test.imageIdToDelete),
+			&ec2.DescribeSnapshotsOutput{},
+			test.awsErr,
+		)
+
+		err := test.s.deleteSnapshotByDescription(context.Background(), test.imageIdToDelete)
+		validateNegativeTests(
+			err,
+			fmt.Sprintf(
+				"Could not get snapshot list for deletion with msg %s",
+				test.awsErr.Error(),
+			),
+			test.s.imageName,
+		)
+
+		var deleteSnapshotErrorTests = []struct {
+			s                  *Client
+			imageIdToDelete    string
+			AWSSnapshots       []*ec2.Snapshot
+			snapshotIdToDelete *string
+			awsErr             awserr.Error
+		}{
+			{
+				s: &Client{
+					svc:                       mockEC2iface,
+					imageNameWithoutTimestamp: "testing123.bak",
+					imageName:                 "testing123.bak.1257894000",
+					timeToSave:                604800,
+					filter:                    filters,
+				},
+				imageIdToDelete: "img-1323949t",
+				AWSSnapshots: []*ec2.Snapshot{
+					{
+						SnapshotId: aws.String("test1"),
+						Description: aws.String(
+							"This is snapshot taken from img-1323949t",
+						),
+					},
+					{
+						SnapshotId: aws.String("test2"),
+						Description: aws.String(
+							"This is snapshot taken from img-fdafkdlkfj",
+						),
+					},
+				},
+				snapshotIdToDelete: aws.String("test1"),
+				awsErr: awserr.New(
+					"01-01",
+					"SnapshotId does not Exist",
+					nil,
+				),
+			},
+			{
+				s: &Client{
+					svc:                       mockEC2iface,
+					imageNameWithoutTimestamp: "testing123.bak",
+					imageName:                 "testing123.bak.1257894000",
+					timeToSave:                604800,
+					filter:                    filters,
+				},
+				imageIdToDelete: "img-1323949t",
+				AWSSnapshots: []*ec2.Snapshot{
+					{
+						SnapshotId: aws.String("test1"),
+						Description: aws.String(
+							"This is snapshot taken from img-1323949f",
+						),
+					},
+					{
+						SnapshotId: aws.String("test2"),
+						Description: aws.String(
+							"This is snapshot taken from img-1323949t",
+						),
+					},
+				},
+				snapshotIdToDelete: aws.String("test2"),
+				awsErr: awserr.New(
+					"01-02",
+					"Something when wrong",
+					nil,
+				),
+			},
+		}
+
+		for _, test := range deleteSnapshotErrorTests {
+			expectSnapshotPages(
+				mockEC2iface,
+				getSnapshotsByDescriptionInput(filters, test.imageIdToDelete),
+				&ec2.DescribeSnapshotsOutput{
+					Snapshots: test.AWSSnapshots,
+				},
+				nil,
+			)
+			if test.snapshotIdToDelete != nil {
+				mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+					gomock.Any(),
+					&ec2.DeleteSnapshotInput{
+						SnapshotId: test.snapshotIdToDelete,
+						DryRun:     aws.Bool(false),
+					},
+				).Return(
+					&ec2.DeleteSnapshotOutput{},
+					test.awsErr,
+				)
+			}
+			err := test.s.deleteSnapshotByDescription(context.Background(), test.imageIdToDelete)
+			var imageName *string
+			for _, snapshot := range test.AWSSnapshots {
+				if *snapshot.SnapshotId == *test.snapshotIdToDelete {
+					imageName = snapshot.Description
+				}
+			}
+			validateNegativeTests(err, test.awsErr.Error(), *imageName)
+		}
+	}
+}
+
+func TestDeleteError(t *testing.T) {
+	tests := []struct {
+		imageName string
+		msg       string
+	}{
+		{
+			imageName: "pd.test.i.20150607",
+			msg:       "Delete failed, image does not exist",
+		},
+		{
+			imageName: "pd.test.i",
+			msg:       "Describe Reservation did not work",
+		},
+	}
+
+	for _, test := range tests {
+		d := DeleteError{
+			test.imageName,
+			test.msg,
+		}
+		expect := fmt.Sprintf(
+			"Image delete failed for image %s with \"%s\"",
+			test.imageName,
+			test.msg,
+		)
+		if d.Error() != expect {
+			t.Errorf("Expected %s got %s", expect, d.Error())
+		}
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var tests = []struct {
+		errs   MultiError
+		expect string
+	}{
+		{
+			errs:   MultiError{errors.New("first")},
+			expect: "first",
+		},
+		{
+			errs:   MultiError{errors.New("first"), errors.New("second")},
+			expect: "2 errors: first; second",
+		},
+	}
+
+	for _, test := range tests {
+		if test.errs.Error() != test.expect {
+			t.Errorf("Expected %s got %s", test.expect, test.errs.Error())
+		}
+	}
+	if err := (MultiError{}).errorOrNil(); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+}
+
+func TestCreateNameWithTimeStamp(t *testing.T) {
+	var (
+		tests = []string{
+			"pd.test.1.bak",
+			"Int_123442.BAK",
+			"Adquire.image.BAK",
+			"ami-49504nj9",
+			"_234354566",
+			"90)9900)0987.bak",
+		}
+		negativeTestsAfter = []string{
+			"pd.test.1.bak",
+			"int-123442.bak",
+			"_1adquire.image.bak",
+			"AMI-49504nj9",
+		}
+		negativeTestsBefore = []string{
+			"pd.test.1.bak",
+			"pss_1adfg2.bak",
+			"adquire.image.bak",
+			"ami-49504nj9",
+		}
+	)
+	for _, test := range tests {
+		expect := fmt.Sprintf(
+			"%s.%s",
+			test,
+			time.Now().Format("20060102150405"),
+		)
+		result := createNameWithTimestamp(test)
+		if result != expect {
+			t.Errorf("Expected %s got %s", expect, result)
+		}
+	}
+	for _, test := range negativeTestsAfter {
+		notExpected := fmt.Sprintf(
+			"%s.%s",
+			test,
+			time.Now().Add(
+				time.Minute*time.Duration(rand.Int31n(100)),
+			).Format("20060102150405"),
+		)
+		result := createNameWithTimestamp(test)
+		if notExpected == result {
+			t.Errorf("Did not expect %s and but got %s", notExpected, result)
+		}
+	}
+	for _, test := range negativeTestsBefore {
+		notExpected := fmt.Sprintf(
+			"%s.%s",
+			test,
+			time.Now().Add(
+				-time.Minute*time.Duration(rand.Int31n(100)),
+			).Format("20060102150405"),
+		)
+		result := createNameWithTimestamp(test)
+		if notExpected == result {
+			t.Errorf("Did not expect %s and but got %s", notExpected, result)
+		}
+	}
+}
+
+func TestParseTagSelectors(t *testing.T) {
+	var tests = []struct {
+		selectors string
+		expect    []*ec2.Filter
+	}{
+		{
+			selectors: "Backup=daily",
+			expect: []*ec2.Filter{
+				{
+					Name:   aws.String("tag:Backup"),
+					Values: []*string{aws.String("daily")},
+				},
+			},
+		},
+		{
+			selectors: "Backup=daily, Env=prod,Critical",
+			expect: []*ec2.Filter{
+				{
+					Name:   aws.String("tag:Backup"),
+					Values: []*string{aws.String("daily")},
+				},
+				{
+					Name:   aws.String("tag:Env"),
+					Values: []*string{aws.String("prod")},
+				},
+				{
+					Name:   aws.String("tag-key"),
+					Values: []*string{aws.String("Critical")},
+				},
+			},
+		},
+		{
+			selectors: "",
+			expect:    []*ec2.Filter{},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := ParseTagSelectors(test.selectors)
+		if err != nil {
+			t.Errorf("Expected nil but got %v", err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+
+	if _, err := ParseTagSelectors("=daily"); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestFindInstances(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var filters = []*ec2.Filter{
+		{
+			Name:   aws.String("tag:Backup"),
+			Values: []*string{aws.String("daily")},
+		},
+	}
+
+	var pages = []*ec2.DescribeInstancesOutput{
+		{
+			Reservations: []*ec2.Reservation{
+				{
+					Instances: []*ec2.Instance{
+						{
+							InstanceId: aws.String("i-1"),
+							State:      &ec2.InstanceState{Name: aws.String("running")},
+						},
+						{
+							InstanceId: aws.String("i-2"),
+							State:      &ec2.InstanceState{Name: aws.String("terminated")},
+						},
+					},
+				},
+			},
+		},
+		{
+			Reservations: []*ec2.Reservation{
+				{
+					Instances: []*ec2.Instance{
+						{
+							InstanceId: aws.String("i-3"),
+							State:      &ec2.InstanceState{Name: aws.String("stopped")},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	mockEC2iface.EXPECT().DescribeInstancesPagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeInstancesInput{Filters: filters},
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeInstancesInput,
+			fn func(*ec2.DescribeInstancesOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			for i, page := range pages {
+				if !fn(page, i == len(pages)-1) {
+					return
+				}
+			}
+		},
+	).Return(nil)
+	instances, err := FindInstances(context.Background(), mockEC2iface, filters)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if len(instances) != 2 ||
+		*instances[0].InstanceId != "i-1" ||
+		*instances[1].InstanceId != "i-3" {
+		t.Errorf("Expected instances i-1 and i-3 but got %v", instances)
+	}
+
+	mockEC2iface.EXPECT().DescribeInstancesPagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeInstancesInput{Filters: filters},
+		gomock.Any(),
+	).Return(errors.New("Some error blah blah"))
+	if _, err := FindInstances(context.Background(), mockEC2iface, filters); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestInstanceImageNames(t *testing.T) {
+	var nameTag = func(s string) []*ec2.Tag {
+		return []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(s)}}
+	}
+
+	var tests = []struct {
+		prefix    string
+		instances []*ec2.Instance
+		expect    map[string]string
+	}{
+		{
+			prefix: "",
+			instances: []*ec2.Instance{
+				{InstanceId: aws.String("i-1"), Tags: nameTag("web01")},
+				{InstanceId: aws.String("i-2")},
+				{InstanceId: aws.String("i-3"), Tags: nameTag("db 01 (primary)")},
+				{InstanceId: aws.String("i-4"), Tags: nameTag("mail:smtp")},
+			},
+			expect: map[string]string{
+				"i-1": "web01",
+				"i-2": "i-2",
+				"i-3": "db 01 (primary)",
+				"i-4": "mail-smtp",
+			},
+		},
+		{
+			prefix: "nightly",
+			instances: []*ec2.Instance{
+				{InstanceId: aws.String("i-1"), Tags: nameTag("web")},
+				{InstanceId: aws.String("i-2"), Tags: nameTag("web")},
+				{InstanceId: aws.String("i-3"), Tags: nameTag("db")},
+			},
+			expect: map[string]string{
+				"i-1": "nightly.web-i-1",
+				"i-2": "nightly.web-i-2",
+				"i-3": "nightly.db",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := InstanceImageNames(test.prefix, test.instances)
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+}
+
+func TestOwnsImage(t *testing.T) {
+	var s = &Client{imageNameWithoutTimestamp: "web"}
+
+	var tests = []struct {
+		image  *ec2.Image
+		expect bool
+	}{
+		{
+			image: &ec2.Image{
+				Name: aws.String("web.20160102030405"),
+				Tags: getBackupTags("web"),
+			},
+			expect: true,
+		},
+		{
+			// tagged images match on the policy tag whatever their name
+			image: &ec2.Image{
+				Name: aws.String("renamed by hand"),
+				Tags: getBackupTags("web"),
+			},
+			expect: true,
+		},
+		{
+			image: &ec2.Image{
+				Name: aws.String("web.20160102030405"),
+				Tags: getBackupTags("web-prod"),
+			},
+			expect: false,
+		},
+		{
+			image:  &ec2.Image{Name: aws.String("web.20160102030405")},
+			expect: true,
+		},
+		{
+			image:  &ec2.Image{Name: aws.String("web-prod.20160102030405")},
+			expect: false,
+		},
+		{
+			image:  &ec2.Image{Name: aws.String("webhooks.20160102030405")},
+			expect: false,
+		},
+		{
+			image:  &ec2.Image{Name: aws.String("web.prod.20160102030405")},
+			expect: false,
+		},
+		{
+			image:  &ec2.Image{Name: aws.String("web.2016010203")},
+			expect: false,
+		},
+		{
+			image:  &ec2.Image{Name: aws.String("web")},
+			expect: false,
+		},
+		{
+			image: &ec2.Image{
+				Name: aws.String("web.20160102030405"),
+				Tags: []*ec2.Tag{
+					{Key: aws.String(tagManagedBy), Value: aws.String("someone-else")},
+				},
+			},
+			expect: true,
+		},
+	}
+
+	for _, test := range tests {
+		result := s.ownsImage(test.image)
+		if result != test.expect {
+			t.Errorf(
+				"Expected %v for %s got %v",
+				test.expect,
+				*test.image.Name,
+				result,
+			)
+		}
+	}
+}
+
+func TestRemoveOldImagePages(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+	}
+
+	var oldImage = func(id string) *ec2.Image {
+		return &ec2.Image{
+			ImageId:      aws.String(id),
+			Name:         aws.String("testing1.bak." + id),
+			Tags:         getBackupTags("testing1.bak"),
+			CreationDate: aws.String("2015-06-07T00:00:00Z"),
+		}
+	}
+
+	// backups past the first page are pruned too
+	mockEC2iface.EXPECT().DescribeImagesPagesWithContext(
+		gomock.Any(),
+		getBackupsInput(nil, "testing1.bak"),
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeImagesInput,
+			fn func(*ec2.DescribeImagesOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			pages := []*ec2.DescribeImagesOutput{
+				{Images: []*ec2.Image{oldImage("ami-123456a")}},
+				{Images: []*ec2.Image{oldImage("ami-123456b")}},
+			}
+			for i, page := range pages {
+				if !fn(page, i == len(pages)-1) {
+					return
+				}
+			}
+		},
+	).Return(nil)
+	for _, id := range []string{"ami-123456a", "ami-123456b"} {
+		mockEC2iface.EXPECT().DeregisterImageWithContext(
+			gomock.Any(),
+			&ec2.DeregisterImageInput{
+				ImageId: aws.String(id),
+				DryRun:  aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeregisterImageOutput{},
+			nil,
+		)
+		expectSnapshotPages(
+			mockEC2iface,
+			getSnapshotsByDescriptionInput(nil, id),
+			&ec2.DescribeSnapshotsOutput{},
+			nil,
+		)
+	}
+	if err := s.removeOldImage(context.Background(), ""); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+}
+
+func TestFindSnapshotsByDescriptionPages(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:       mockEC2iface,
+		imageName: "testing1.bak.1257894000",
+	}
+
+	var pages = []*ec2.DescribeSnapshotsOutput{
+		{
+			Snapshots: []*ec2.Snapshot{
+				{
+					SnapshotId:  aws.String("snap-1"),
+					Description: aws.String("This snapshot is taken from ami-123456b"),
+				},
+			},
+		},
+		{
+			Snapshots: []*ec2.Snapshot{
+				{
+					SnapshotId:  aws.String("snap-2"),
+					Description: aws.String("This snapshot is taken from ami-123456a"),
+				},
+			},
+		},
+		{
+			Snapshots: []*ec2.Snapshot{
+				{
+					SnapshotId:  aws.String("snap-3"),
+					Description: aws.String("This snapshot is taken from ami-123456a"),
+				},
+			},
+		},
+	}
+
+	// every page is read, so no snapshot of the image is left behind
+	var read int
+	mockEC2iface.EXPECT().DescribeSnapshotsPagesWithContext(
+		gomock.Any(),
+		getSnapshotsByDescriptionInput(nil, "ami-123456a"),
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeSnapshotsInput,
+			fn func(*ec2.DescribeSnapshotsOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			for i, page := range pages {
+				read++
+				if !fn(page, i == len(pages)-1) {
+					return
+				}
+			}
+		},
+	).Return(nil)
+	for _, id := range []string{"snap-2", "snap-3"} {
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		)
+	}
+	if err := s.deleteSnapshotByDescription(context.Background(), "ami-123456a"); err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if read != 3 {
+		t.Errorf("Expected 3 pages read got %d", read)
+	}
+}
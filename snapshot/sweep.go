@@ -0,0 +1,152 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// describeImagesBatchSize bounds how many image IDs go into one filter.
+const describeImagesBatchSize = 200
+
+// amiIDPattern finds the AMI named in descriptions such as
+// "Created by CreateImage(i-1234abc) for ami-1234abcd from vol-1234abcd".
+var amiIDPattern = regexp.MustCompile(`\bami-[0-9a-f]+\b`)
+
+// SweepOrphans finds snapshots under filter whose description references an
+// AMI that no longer exists and that are older than grace seconds.  Orphans
+// are deleted unless dryRun is set; either way they are returned as plan
+// items.  A failed delete does not stop the sweep, the first error is
+// returned once every orphan has been tried.
+func SweepOrphans(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	filter []*ec2.Filter,
+	grace int64,
+	dryRun bool,
+) ([]PlanItem, error) {
+	var snapshots []*ec2.Snapshot
+	err := svc.DescribeSnapshotsPagesWithContext(
+		ctx,
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds: []*string{aws.String("self")},
+			Filters:  filter,
+		},
+		func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			snapshots = append(snapshots, page.Snapshots...)
+			return true
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Could not get snapshot list for sweep with msg %s",
+			err.Error(),
+		)
+	}
+	var (
+		seen     = map[string]bool{}
+		imageIDs = []string{}
+	)
+	for _, snapshot := range snapshots {
+		if snapshot.Description == nil {
+			continue
+		}
+		imageID := amiIDPattern.FindString(*snapshot.Description)
+		if imageID == "" {
+			continue
+		}
+		if !seen[imageID] {
+			seen[imageID] = true
+			imageIDs = append(imageIDs, imageID)
+		}
+	}
+	existing, err := existingImages(ctx, svc, imageIDs)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		result   = []PlanItem{}
+		firstErr error
+		cutoff   = time.Now().Add(-time.Duration(grace) * time.Second)
+	)
+	for _, snapshot := range snapshots {
+		if snapshot.Description == nil {
+			continue
+		}
+		imageID := amiIDPattern.FindString(*snapshot.Description)
+		if imageID == "" || existing[imageID] {
+			continue
+		}
+		if snapshot.StartTime == nil || snapshot.StartTime.After(cutoff) {
+			continue
+		}
+		result = append(result, PlanItem{
+			Action:   ActionDelete,
+			Resource: ResourceSnapshot,
+			ID:       *snapshot.SnapshotId,
+			Reason:   "references missing " + imageID,
+		})
+		if dryRun {
+			continue
+		}
+		_, err = svc.DeleteSnapshotWithContext(
+			ctx,
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: snapshot.SnapshotId,
+				DryRun:     aws.Bool(false),
+			},
+		)
+		if err != nil && firstErr == nil {
+			firstErr = &DeleteError{*snapshot.Description, err.Error()}
+		}
+	}
+	return result, firstErr
+}
+
+// existingImages reports which of imageIDs still exist.  A filter is used
+// rather than ImageIds because the latter fails outright on unknown IDs.
+func existingImages(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	imageIDs []string,
+) (map[string]bool, error) {
+	var result = map[string]bool{}
+	for start := 0; start < len(imageIDs); start += describeImagesBatchSize {
+		end := start + describeImagesBatchSize
+		if end > len(imageIDs) {
+			end = len(imageIDs)
+		}
+		err := svc.DescribeImagesPagesWithContext(
+			ctx,
+			&ec2.DescribeImagesInput{
+				Filters: []*ec2.Filter{
+					{
+						Name:   aws.String("image-id"),
+						Values: aws.StringSlice(imageIDs[start:end]),
+					},
+				},
+			},
+			func(page *ec2.DescribeImagesOutput, lastPage bool) bool {
+				for _, image := range page.Images {
+					if aws.StringValue(image.State) == ec2.ImageStateDeregistered {
+						continue
+					}
+					result[*image.ImageId] = true
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Failed to describe images with error %s",
+				err.Error(),
+			)
+		}
+	}
+	return result, nil
+}
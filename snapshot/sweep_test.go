@@ -0,0 +1,195 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestSweepOrphans(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var filters = []*ec2.Filter{
+		{
+			Name: aws.String("owner-id"),
+			Values: []*string{
+				aws.String("533779774295"),
+			},
+		},
+	}
+
+	var daysAgo = func(d int) *time.Time {
+		return aws.Time(time.Now().Add(-time.Duration(d) * 24 * time.Hour))
+	}
+
+	var awsSnapshots = []*ec2.Snapshot{
+		{
+			SnapshotId: aws.String("snap-1"),
+			Description: aws.String(
+				"Created by CreateImage(i-1234abc) for ami-1111aaaa from vol-1",
+			),
+			StartTime: daysAgo(10),
+		},
+		{
+			SnapshotId: aws.String("snap-2"),
+			Description: aws.String(
+				"Created by CreateImage(i-1234abc) for ami-2222bbbb from vol-2",
+			),
+			StartTime: daysAgo(10),
+		},
+		{
+			SnapshotId: aws.String("snap-3"),
+			Description: aws.String(
+				"Created by CreateImage(i-1234abc) for ami-2222bbbb from vol-3",
+			),
+			StartTime: daysAgo(10),
+		},
+		{
+			// orphaned, but still inside the grace period
+			SnapshotId: aws.String("snap-4"),
+			Description: aws.String(
+				"Created by CreateImage(i-1234abc) for ami-3333cccc from vol-4",
+			),
+			StartTime: daysAgo(0),
+		},
+		{
+			SnapshotId:  aws.String("snap-5"),
+			Description: aws.String("Hand made snapshot of vol-5"),
+			StartTime:   daysAgo(10),
+		},
+		{
+			SnapshotId: aws.String("snap-6"),
+			StartTime:  daysAgo(10),
+		},
+	}
+
+	var expect = []PlanItem{
+		{
+			Action:   ActionDelete,
+			Resource: ResourceSnapshot,
+			ID:       "snap-2",
+			Reason:   "references missing ami-2222bbbb",
+		},
+		{
+			Action:   ActionDelete,
+			Resource: ResourceSnapshot,
+			ID:       "snap-3",
+			Reason:   "references missing ami-2222bbbb",
+		},
+	}
+
+	var expectDescribe = func() {
+		expectSnapshotPages(
+			mockEC2iface,
+			&ec2.DescribeSnapshotsInput{
+				OwnerIds: aws.StringSlice([]string{"self"}),
+				Filters:  filters,
+			},
+			&ec2.DescribeSnapshotsOutput{Snapshots: awsSnapshots},
+			nil,
+		)
+		expectImagePages(
+			mockEC2iface,
+			&ec2.DescribeImagesInput{
+				Filters: []*ec2.Filter{
+					{
+						Name: aws.String("image-id"),
+						Values: aws.StringSlice([]string{
+							"ami-1111aaaa",
+							"ami-2222bbbb",
+							"ami-3333cccc",
+						}),
+					},
+				},
+			},
+			&ec2.DescribeImagesOutput{
+				Images: []*ec2.Image{
+					{ImageId: aws.String("ami-1111aaaa")},
+				},
+			},
+			nil,
+		)
+	}
+
+	// dry run reports without deleting
+	expectDescribe()
+	result, err := SweepOrphans(context.Background(), mockEC2iface, filters, 86400, true)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %+v got %+v", expect, result)
+	}
+
+	expectDescribe()
+	for _, id := range []string{"snap-2", "snap-3"} {
+		mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+			gomock.Any(),
+			&ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(id),
+				DryRun:     aws.Bool(false),
+			},
+		).Return(
+			&ec2.DeleteSnapshotOutput{},
+			nil,
+		)
+	}
+	result, err = SweepOrphans(context.Background(), mockEC2iface, filters, 86400, false)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %+v got %+v", expect, result)
+	}
+
+	expectSnapshotPages(
+		mockEC2iface,
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds: aws.StringSlice([]string{"self"}),
+			Filters:  filters,
+		},
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := SweepOrphans(context.Background(), mockEC2iface, filters, 86400, false); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestAMIIDPattern(t *testing.T) {
+	var tests = []struct {
+		description string
+		expect      string
+	}{
+		{
+			description: "Created by CreateImage(i-1234abc) for ami-1234abcd from vol-1234abcd",
+			expect:      "ami-1234abcd",
+		},
+		{
+			description: "Copied for DestinationAmi ami-0123456789abcdef0 from SourceAmi ami-1234abcd",
+			expect:      "ami-0123456789abcdef0",
+		},
+		{
+			description: "This snapshot is taken from vol-1234abcd",
+			expect:      "",
+		},
+		{
+			description: "salami-1234",
+			expect:      "",
+		},
+	}
+
+	for _, test := range tests {
+		result := amiIDPattern.FindString(test.description)
+		if result != test.expect {
+			t.Errorf("Expected %s got %s", test.expect, result)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Tags written on every image and snapshot this tool creates.  Keys under
+// tagPrefix are reserved and cannot be set from the config.
+const (
+	tagPrefix         = "ec2_snapshot:"
+	tagManagedBy      = tagPrefix + "managed-by"
+	tagPolicy         = tagPrefix + "policy"
+	tagSourceInstance = tagPrefix + "source-instance"
+	tagSourceVolume   = tagPrefix + "source-volume"
+	tagExpiresAt      = tagPrefix + "expires-at"
+	tagSet            = tagPrefix + "set"
+	tagDevice         = tagPrefix + "device"
+	tagCreatedAt      = tagPrefix + "created-at"
+
+	// tagRestoredFrom is written on instances Restore launches instead,
+	// naming the image they were launched from.
+	tagRestoredFrom = tagPrefix + "restored-from"
+
+	managedByValue = "ec2_snapshot"
+)
+
+// backupTags returns the configured static tags plus the built-in ones for a
+// backup of instanceID taken at now, sorted by key.  The policy tag holds
+// the image name without its timestamp and expires-at is when time-to-save
+// alone would let the backup be pruned.
+func (s *Client) backupTags(instanceID string, now time.Time) []*ec2.Tag {
+	return toEC2Tags(s.backupTagValues(instanceID, now))
+}
+
+// snapshotSetTags returns backupTags plus the name of the snapshot set,
+// which is the backup's timestamped name, and when it was taken.
+func (s *Client) snapshotSetTags(instanceID string, now time.Time) []*ec2.Tag {
+	var tags = s.backupTagValues(instanceID, now)
+	tags[tagSet] = s.imageName
+	tags[tagCreatedAt] = now.UTC().Format(time.RFC3339)
+	return toEC2Tags(tags)
+}
+
+// volumeSnapshotTags is snapshotSetTags for a snapshot of volumeID, which
+// records the volume instead of an instance.  The snapshot is also given
+// the set's name as its Name tag unless one is configured.
+func (s *Client) volumeSnapshotTags(volumeID string, now time.Time) []*ec2.Tag {
+	var tags = s.backupTagValues("", now)
+	delete(tags, tagSourceInstance)
+	tags[tagSourceVolume] = volumeID
+	tags[tagSet] = s.imageName
+	tags[tagCreatedAt] = now.UTC().Format(time.RFC3339)
+	if _, ok := tags["Name"]; !ok {
+		tags["Name"] = s.imageName
+	}
+	return toEC2Tags(tags)
+}
+
+func (s *Client) backupTagValues(
+	instanceID string,
+	now time.Time,
+) map[string]string {
+	var tags = map[string]string{}
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	tags[tagManagedBy] = managedByValue
+	tags[tagPolicy] = s.imageNameWithoutTimestamp
+	tags[tagSourceInstance] = instanceID
+	tags[tagExpiresAt] = now.Add(
+		time.Duration(s.timeToSave) * time.Second,
+	).UTC().Format(time.RFC3339)
+	return tags
+}
+
+func toEC2Tags(tags map[string]string) []*ec2.Tag {
+	var keys = []string{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var result = []*ec2.Tag{}
+	for _, k := range keys {
+		result = append(
+			result,
+			&ec2.Tag{Key: aws.String(k), Value: aws.String(tags[k])},
+		)
+	}
+	return result
+}
+
+// tagSpecifications applies tags to both the image and the snapshots
+// CreateImage takes.
+func tagSpecifications(tags []*ec2.Tag) []*ec2.TagSpecification {
+	return []*ec2.TagSpecification{
+		{ResourceType: aws.String(ec2.ResourceTypeImage), Tags: tags},
+		{ResourceType: aws.String(ec2.ResourceTypeSnapshot), Tags: tags},
+	}
+}
+
+func validateTags(tags map[string]string) error {
+	for k := range tags {
+		if strings.HasPrefix(k, tagPrefix) || strings.HasPrefix(k, "aws:") {
+			return fmt.Errorf("Tag key %s uses a reserved prefix", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestBackupTags(t *testing.T) {
+	var now = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		s      *Client
+		expect []*ec2.Tag
+	}{
+		{
+			s: &Client{
+				imageNameWithoutTimestamp: "testing1.bak",
+				timeToSave:                604800,
+			},
+			expect: []*ec2.Tag{
+				{
+					Key:   aws.String("ec2_snapshot:expires-at"),
+					Value: aws.String("2016-03-22T12:00:00Z"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:managed-by"),
+					Value: aws.String("ec2_snapshot"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:policy"),
+					Value: aws.String("testing1.bak"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:source-instance"),
+					Value: aws.String("i-1234abc"),
+				},
+			},
+		},
+		{
+			s: &Client{
+				imageNameWithoutTimestamp: "testing1.bak",
+				timeToSave:                3600,
+				tags: map[string]string{
+					"CostCenter": "ops",
+					"Team":       "infra",
+				},
+			},
+			expect: []*ec2.Tag{
+				{
+					Key:   aws.String("CostCenter"),
+					Value: aws.String("ops"),
+				},
+				{
+					Key:   aws.String("Team"),
+					Value: aws.String("infra"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:expires-at"),
+					Value: aws.String("2016-03-15T13:00:00Z"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:managed-by"),
+					Value: aws.String("ec2_snapshot"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:policy"),
+					Value: aws.String("testing1.bak"),
+				},
+				{
+					Key:   aws.String("ec2_snapshot:source-instance"),
+					Value: aws.String("i-1234abc"),
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := test.s.backupTags("i-1234abc", now)
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("Expected %v got %v", test.expect, result)
+		}
+	}
+}
+
+func TestTagSpecifications(t *testing.T) {
+	var tags = []*ec2.Tag{
+		{Key: aws.String("Team"), Value: aws.String("infra")},
+	}
+	var expect = []*ec2.TagSpecification{
+		{ResourceType: aws.String("image"), Tags: tags},
+		{ResourceType: aws.String("snapshot"), Tags: tags},
+	}
+	result := tagSpecifications(tags)
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %v got %v", expect, result)
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	var tests = []struct {
+		tags      map[string]string
+		expectErr bool
+	}{
+		{map[string]string{"Team": "infra"}, false},
+		{map[string]string{}, false},
+		{map[string]string{"ec2_snapshot:policy": "other"}, true},
+		{map[string]string{"aws:cloudformation:stack-name": "x"}, true},
+	}
+
+	for _, test := range tests {
+		err := validateTags(test.tags)
+		if test.expectErr && err == nil {
+			t.Errorf("Expected an error for %v but got nil", test.tags)
+		}
+		if !test.expectErr && err != nil {
+			t.Errorf("Expected nil for %v but got %v", test.tags, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// createVolumeSnapshot snapshots volumeID as a set of one named
+// s.imageName, waits for it to complete and prunes the volume's old
+// snapshots.  It returns the new snapshot's ID.
+func (s *Client) createVolumeSnapshot(
+	ctx context.Context,
+	volumeID string,
+	now time.Time,
+) (string, error) {
+	var (
+		snapshot *ec2.Snapshot
+		errs     MultiError
+	)
+	err := s.quiesce(ctx, volumeID, func() error {
+		var err error
+		snapshot, err = s.svc.CreateSnapshotWithContext(
+			ctx,
+			&ec2.CreateSnapshotInput{
+				VolumeId: aws.String(volumeID),
+				Description: aws.String(fmt.Sprintf(
+					"Created by %s for %s from %s",
+					managedByValue,
+					s.imageName,
+					volumeID,
+				)),
+				TagSpecifications: []*ec2.TagSpecification{
+					{
+						ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+						Tags:         s.volumeSnapshotTags(volumeID, now),
+					},
+				},
+			},
+		)
+		return err
+	})
+	if snapshot == nil {
+		return "", &CreateError{
+			s.imageName,
+			fmt.Sprintf("Failed to create snapshot b/c of %s", err.Error()),
+		}
+	}
+	if err != nil {
+		errs = append(errs, &CreateError{s.imageName, err.Error()})
+	}
+	s.newImageID = s.imageName
+	if err := s.waitForSnapshots(ctx, []string{*snapshot.SnapshotId}); err != nil {
+		return *snapshot.SnapshotId, append(errs, err)
+	}
+	if err := s.removeOldSnapshotSets(ctx); err != nil {
+		errs = append(errs, &DeleteError{s.imageName, err.Error()})
+	}
+	return *snapshot.SnapshotId, errs.errorOrNil()
+}
+
+// FindVolumes returns every volume matching filters that can still be
+// snapshotted, i.e. is not being deleted or broken.
+func FindVolumes(
+	ctx context.Context,
+	svc ec2iface.EC2API,
+	filters []*ec2.Filter,
+) ([]*ec2.Volume, error) {
+	var result = []*ec2.Volume{}
+	err := svc.DescribeVolumesPagesWithContext(
+		ctx,
+		&ec2.DescribeVolumesInput{Filters: filters},
+		func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+			for _, volume := range page.Volumes {
+				switch aws.StringValue(volume.State) {
+				case "deleting", "deleted", "error":
+					continue
+				}
+				result = append(result, volume)
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe volumes with error %s", err.Error())
+	}
+	return result, nil
+}
+
+// VolumeBackupNames is InstanceImageNames for volumes: each volume's
+// backups are named after its Name tag, or its volume ID if it has none.
+func VolumeBackupNames(
+	prefix string,
+	volumes []*ec2.Volume,
+) map[string]string {
+	var tags = map[string][]*ec2.Tag{}
+	for _, volume := range volumes {
+		tags[*volume.VolumeId] = volume.Tags
+	}
+	return backupNames(prefix, tags)
+}
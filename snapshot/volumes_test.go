@@ -0,0 +1,221 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestCreateVolumeSnapshot(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var (
+		now = time.Date(2016, time.March, 15, 12, 0, 0, 0, time.UTC)
+		s   = &Client{
+			svc:                       mockEC2iface,
+			imageNameWithoutTimestamp: "data01",
+			imageName:                 "data01.20160315120000",
+			timeToSave:                604800,
+			waitTimeout:               time.Second,
+			pollInterval:              time.Millisecond,
+			mode:                      ModeVolume,
+		}
+	)
+
+	mockEC2iface.EXPECT().CreateSnapshotWithContext(
+		gomock.Any(),
+		&ec2.CreateSnapshotInput{
+			VolumeId: aws.String("vol-1"),
+			Description: aws.String(
+				"Created by ec2_snapshot for data01.20160315120000 from vol-1",
+			),
+			TagSpecifications: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String("Name"),
+							Value: aws.String("data01.20160315120000"),
+						},
+						{
+							Key:   aws.String(tagCreatedAt),
+							Value: aws.String("2016-03-15T12:00:00Z"),
+						},
+						{
+							Key:   aws.String(tagExpiresAt),
+							Value: aws.String("2016-03-22T12:00:00Z"),
+						},
+						{
+							Key:   aws.String(tagManagedBy),
+							Value: aws.String(managedByValue),
+						},
+						{
+							Key:   aws.String(tagPolicy),
+							Value: aws.String("data01"),
+						},
+						{
+							Key:   aws.String(tagSet),
+							Value: aws.String("data01.20160315120000"),
+						},
+						{
+							Key:   aws.String(tagSourceVolume),
+							Value: aws.String("vol-1"),
+						},
+					},
+				},
+			},
+		},
+	).Return(
+		&ec2.Snapshot{SnapshotId: aws.String("snap-1")},
+		nil,
+	)
+	expectSnapshotPages(
+		mockEC2iface,
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds:    aws.StringSlice([]string{"self"}),
+			SnapshotIds: aws.StringSlice([]string{"snap-1"}),
+		},
+		&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("snap-1"),
+					State:      aws.String(ec2.SnapshotStateCompleted),
+				},
+			},
+		},
+		nil,
+	)
+	// snapshots of the volume older than time-to-save are pruned
+	expectSnapshotPages(
+		mockEC2iface,
+		s.describeSnapshotSetsInput(),
+		&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{
+				getSetSnapshot("snap-1", "data01", "data01.20160315120000"),
+				getSetSnapshot("snap-2", "data01", "data01.20150101000000"),
+			},
+		},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+		gomock.Any(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-2"),
+			DryRun:     aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeleteSnapshotOutput{},
+		nil,
+	)
+	result, err := s.createVolumeSnapshot(context.Background(), "vol-1", now)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if result != "snap-1" {
+		t.Errorf("Expected snap-1 got %s", result)
+	}
+
+	mockEC2iface.EXPECT().CreateSnapshotWithContext(
+		gomock.Any(),
+		gomock.Any(),
+	).Return(
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := s.createVolumeSnapshot(context.Background(), "vol-1", now); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestFindVolumes(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var filters = []*ec2.Filter{
+		{
+			Name:   aws.String("tag:Backup"),
+			Values: []*string{aws.String("daily")},
+		},
+	}
+
+	var pages = []*ec2.DescribeVolumesOutput{
+		{
+			Volumes: []*ec2.Volume{
+				{VolumeId: aws.String("vol-1"), State: aws.String("in-use")},
+				{VolumeId: aws.String("vol-2"), State: aws.String("deleting")},
+			},
+		},
+		{
+			Volumes: []*ec2.Volume{
+				{VolumeId: aws.String("vol-3"), State: aws.String("available")},
+			},
+		},
+	}
+
+	mockEC2iface.EXPECT().DescribeVolumesPagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeVolumesInput{Filters: filters},
+		gomock.Any(),
+	).Do(
+		func(
+			_ aws.Context,
+			_ *ec2.DescribeVolumesInput,
+			fn func(*ec2.DescribeVolumesOutput, bool) bool,
+			_ ...request.Option,
+		) {
+			for i, page := range pages {
+				if !fn(page, i == len(pages)-1) {
+					return
+				}
+			}
+		},
+	).Return(nil)
+	volumes, err := FindVolumes(context.Background(), mockEC2iface, filters)
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if len(volumes) != 2 ||
+		*volumes[0].VolumeId != "vol-1" ||
+		*volumes[1].VolumeId != "vol-3" {
+		t.Errorf("Expected volumes vol-1 and vol-3 but got %v", volumes)
+	}
+
+	mockEC2iface.EXPECT().DescribeVolumesPagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeVolumesInput{Filters: filters},
+		gomock.Any(),
+	).Return(errors.New("Some error blah blah"))
+	if _, err := FindVolumes(context.Background(), mockEC2iface, filters); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestVolumeBackupNames(t *testing.T) {
+	var volumes = []*ec2.Volume{
+		{
+			VolumeId: aws.String("vol-1"),
+			Tags:     []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}},
+		},
+		{
+			VolumeId: aws.String("vol-2"),
+			Tags:     []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}},
+		},
+		{VolumeId: aws.String("vol-3")},
+	}
+	var expect = map[string]string{
+		"vol-1": "nightly.data-vol-1",
+		"vol-2": "nightly.data-vol-2",
+		"vol-3": "nightly.vol-3",
+	}
+	if result := VolumeBackupNames("nightly", volumes); !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected %v got %v", expect, result)
+	}
+}
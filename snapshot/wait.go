@@ -0,0 +1,229 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const defaultPollInterval = 15 * time.Second
+
+// waitForImage polls DescribeImages until imageID is available and returns
+// it.  An image that ends up failed is deregistered, along with any
+// snapshots it took, so that a broken backup is never counted as the
+// newest.  Old backups must not be pruned unless this returns nil.
+func (s *Client) waitForImage(
+	ctx context.Context,
+	imageID string,
+) (*ec2.Image, error) {
+	var (
+		interval = s.pollInterval
+		deadline = time.Now().Add(s.waitTimeout)
+	)
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	for {
+		resp, err := s.svc.DescribeImagesWithContext(
+			ctx,
+			&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageID)}},
+		)
+		if err != nil && !isNotFound(err) {
+			return nil, &CreateError{
+				s.imageName,
+				fmt.Sprintf("Failed to describe new image with error %s", err.Error()),
+			}
+		}
+		if err == nil && len(resp.Images) > 0 {
+			image := resp.Images[0]
+			switch aws.StringValue(image.State) {
+			case ec2.ImageStateAvailable:
+				return image, nil
+			case ec2.ImageStateFailed, ec2.ImageStateError:
+				return nil, s.discardFailedImage(ctx, image)
+			}
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return nil, &CreateError{
+				s.imageName,
+				fmt.Sprintf(
+					"Image %s was not available after %s",
+					imageID,
+					s.waitTimeout,
+				),
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, &CreateError{
+				s.imageName,
+				fmt.Sprintf(
+					"Stopped waiting for image %s b/c of %s",
+					imageID,
+					ctx.Err().Error(),
+				),
+			}
+		case <-time.After(interval):
+		}
+	}
+}
+
+// discardFailedImage deregisters a failed image and deletes its snapshots,
+// returning an error describing why the image failed.
+func (s *Client) discardFailedImage(
+	ctx context.Context,
+	image *ec2.Image,
+) error {
+	ctx, cancel := withoutCancel(ctx)
+	defer cancel()
+	var reason = aws.StringValue(image.State)
+	if image.StateReason != nil && image.StateReason.Message != nil {
+		reason = *image.StateReason.Message
+	}
+	msg := fmt.Sprintf("Image %s failed with %s", *image.ImageId, reason)
+	_, err := s.svc.DeregisterImageWithContext(
+		ctx,
+		&ec2.DeregisterImageInput{
+			ImageId: image.ImageId,
+			DryRun:  aws.Bool(false),
+		},
+	)
+	if err != nil {
+		return &CreateError{
+			s.imageName,
+			fmt.Sprintf(
+				"%s and could not be deregistered b/c of %s",
+				msg,
+				err.Error(),
+			),
+		}
+	}
+	// a failed image has no legacy snapshots worth searching descriptions for
+	if len(snapshotIDs(image)) > 0 {
+		if err := s.deleteImageSnapshots(ctx, image); err != nil {
+			return &CreateError{
+				s.imageName,
+				fmt.Sprintf(
+					"%s and its snapshots could not be deleted b/c of %s",
+					msg,
+					err.Error(),
+				),
+			}
+		}
+	}
+	return &CreateError{s.imageName, msg}
+}
+
+// waitForSnapshots polls DescribeSnapshots until every snapshot in ids has
+// completed.  If any of them fails the whole set is deleted, so that a
+// partial set is never counted as the newest backup.  Old sets must not be
+// pruned unless this returns nil.
+func (s *Client) waitForSnapshots(ctx context.Context, ids []string) error {
+	var (
+		interval = s.pollInterval
+		deadline = time.Now().Add(s.waitTimeout)
+	)
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	for {
+		var (
+			completed int
+			failed    *ec2.Snapshot
+		)
+		err := s.svc.DescribeSnapshotsPagesWithContext(
+			ctx,
+			&ec2.DescribeSnapshotsInput{
+				OwnerIds:    []*string{aws.String("self")},
+				SnapshotIds: aws.StringSlice(ids),
+			},
+			func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+				for _, snapshot := range page.Snapshots {
+					switch aws.StringValue(snapshot.State) {
+					case ec2.SnapshotStateCompleted:
+						completed++
+					case ec2.SnapshotStateError:
+						failed = snapshot
+					}
+				}
+				return true
+			},
+		)
+		if err != nil && !hasCode(err, "InvalidSnapshot.NotFound") {
+			return &CreateError{
+				s.imageName,
+				fmt.Sprintf("Failed to describe new snapshots with error %s", err.Error()),
+			}
+		}
+		if failed != nil {
+			return s.discardFailedSnapshots(ctx, ids, failed)
+		}
+		if err == nil && completed == len(ids) {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return &CreateError{
+				s.imageName,
+				fmt.Sprintf(
+					"Snapshots of %s were not completed after %s",
+					s.imageName,
+					s.waitTimeout,
+				),
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return &CreateError{
+				s.imageName,
+				fmt.Sprintf(
+					"Stopped waiting for snapshots of %s b/c of %s",
+					s.imageName,
+					ctx.Err().Error(),
+				),
+			}
+		case <-time.After(interval):
+		}
+	}
+}
+
+// discardFailedSnapshots deletes every snapshot of a set in which failed
+// did not complete, returning an error describing why.
+func (s *Client) discardFailedSnapshots(
+	ctx context.Context,
+	ids []string,
+	failed *ec2.Snapshot,
+) error {
+	var reason = aws.StringValue(failed.State)
+	if failed.StateMessage != nil {
+		reason = *failed.StateMessage
+	}
+	msg := fmt.Sprintf("Snapshot %s failed with %s", *failed.SnapshotId, reason)
+	var set = backup{id: s.imageName}
+	for _, id := range ids {
+		set.snapshots = append(set.snapshots, &ec2.Snapshot{SnapshotId: aws.String(id)})
+	}
+	if err := s.deleteSnapshotSet(ctx, set); err != nil {
+		return &CreateError{
+			s.imageName,
+			fmt.Sprintf(
+				"%s and the set could not be deleted b/c of %s",
+				msg,
+				err.Error(),
+			),
+		}
+	}
+	return &CreateError{s.imageName, msg}
+}
+
+// isNotFound reports whether err is AWS saying an ID does not exist yet,
+// which happens for a short while after an image is created.
+func isNotFound(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == "InvalidAMIID.NotFound"
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+)
+
+func TestWaitForImage(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		waitTimeout:               time.Second,
+		pollInterval:              time.Millisecond,
+	}
+
+	var describe = &ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String("ami-123456a")},
+	}
+
+	var image = func(state string) *ec2.DescribeImagesOutput {
+		return &ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId: aws.String("ami-123456a"),
+					State:   aws.String(state),
+					BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+						{
+							DeviceName: aws.String("/dev/xvda"),
+							Ebs: &ec2.EbsBlockDevice{
+								SnapshotId: aws.String("snap-1"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	// not yet visible, then pending, then available
+	gomock.InOrder(
+		mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), describe).Return(
+			nil,
+			awserr.New("InvalidAMIID.NotFound", "not found", nil),
+		),
+		mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), describe).Return(
+			image(ec2.ImageStatePending),
+			nil,
+		).Times(2),
+		mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), describe).Return(
+			image(ec2.ImageStateAvailable),
+			nil,
+		),
+	)
+	result, err := s.waitForImage(context.Background(), "ami-123456a")
+	if err != nil {
+		t.Errorf("Expected nil but got %v", err)
+	}
+	if result == nil || *result.ImageId != "ami-123456a" {
+		t.Errorf("Expected image ami-123456a but got %v", result)
+	}
+
+	// a failed image is cleaned up and reported
+	mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), describe).Return(
+		image(ec2.ImageStateFailed),
+		nil,
+	)
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeleteSnapshotWithContext(
+		gomock.Any(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1"),
+			DryRun:     aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeleteSnapshotOutput{},
+		nil,
+	)
+	if _, err := s.waitForImage(context.Background(), "ami-123456a"); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+
+	// other errors are not retried
+	mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), describe).Return(
+		nil,
+		errors.New("Some error blah blah"),
+	)
+	if _, err := s.waitForImage(context.Background(), "ami-123456a"); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+
+	// giving up leaves the pending image alone
+	s.waitTimeout = 20 * time.Millisecond
+	mockEC2iface.EXPECT().DescribeImagesWithContext(gomock.Any(), describe).Return(
+		image(ec2.ImageStatePending),
+		nil,
+	).MinTimes(1)
+	if _, err := s.waitForImage(context.Background(), "ami-123456a"); err == nil {
+		t.Error("Expected an error but got nil")
+	}
+}
+
+func TestCreateImageDoesNotPruneFailedImage(t *testing.T) {
+	mockEC2iface, ctrl := getMocks(t)
+	defer ctrl.Finish()
+
+	var s = &Client{
+		svc:                       mockEC2iface,
+		imageNameWithoutTimestamp: "testing1.bak",
+		imageName:                 "testing1.bak.1257894000",
+		timeToSave:                604800,
+		waitTimeout:               time.Second,
+		pollInterval:              time.Millisecond,
+	}
+
+	var params = &ec2.CreateImageInput{
+		Name:       aws.String("testing1.bak.1257894000"),
+		InstanceId: aws.String("i-1234abc"),
+	}
+
+	mockEC2iface.EXPECT().CreateImageWithContext(gomock.Any(), params).Return(
+		&ec2.CreateImageOutput{ImageId: aws.String("ami-123456a")},
+		nil,
+	)
+	mockEC2iface.EXPECT().DescribeImagesWithContext(
+		gomock.Any(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-123456a")},
+		},
+	).Return(
+		&ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId: aws.String("ami-123456a"),
+					State:   aws.String(ec2.ImageStateFailed),
+				},
+			},
+		},
+		nil,
+	)
+	mockEC2iface.EXPECT().DeregisterImageWithContext(
+		gomock.Any(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-123456a"),
+			DryRun:  aws.Bool(false),
+		},
+	).Return(
+		&ec2.DeregisterImageOutput{},
+		nil,
+	)
+	// no DescribeImages over the old backups is expected
+	id, err := s.createImage(context.Background(), params)
+	if err == nil {
+		t.Error("Expected an error but got nil")
+	}
+	if id != "ami-123456a" {
+		t.Errorf("Expected ami-123456a got %q", id)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	regionPattern     = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	instanceIDPattern = regexp.MustCompile(`^i-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	volumeIDPattern   = regexp.MustCompile(`^vol-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	yamlLinePattern   = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+	unknownKeyPattern = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// Filter names documented for each call filters are sent to.  Every call
+// also takes tag:<key> and tag-key, and a trailing .* allows any name
+// under that prefix.  'filters' is sent to DescribeImages and
+// DescribeSnapshots alike, so only names both accept are allowed.
+var (
+	backupFilterNames = filterNames(
+		"description",
+		"owner-alias",
+		"owner-id",
+	)
+	instanceFilterNames = filterNames(
+		"affinity", "architecture", "availability-zone",
+		"block-device-mapping.attach-time",
+		"block-device-mapping.delete-on-termination",
+		"block-device-mapping.device-name",
+		"block-device-mapping.status",
+		"block-device-mapping.volume-id",
+		"client-token", "dns-name", "group-id", "group-name",
+		"hibernation-options.configured", "host-id", "hypervisor",
+		"iam-instance-profile.arn", "image-id", "instance-id",
+		"instance-lifecycle", "instance-state-code", "instance-state-name",
+		"instance-type", "instance.group-id", "instance.group-name",
+		"ip-address", "kernel-id", "key-name", "launch-index",
+		"launch-time", "metadata-options.http-endpoint",
+		"metadata-options.http-put-response-hop-limit",
+		"metadata-options.http-tokens", "monitoring-state",
+		"network-interface.*", "owner-id",
+		"placement-group-name", "placement-partition-number", "platform",
+		"private-dns-name", "private-ip-address", "product-code",
+		"product-code.type", "ramdisk-id", "reason", "requester-id",
+		"reservation-id", "root-device-name", "root-device-type",
+		"source-dest-check", "spot-instance-request-id",
+		"state-reason-code", "state-reason-message", "subnet-id",
+		"tenancy", "virtualization-type", "vpc-id",
+	)
+	volumeFilterNames = filterNames(
+		"attachment.attach-time", "attachment.delete-on-termination",
+		"attachment.device", "attachment.instance-id", "attachment.status",
+		"availability-zone", "create-time", "encrypted", "fast-restored",
+		"multi-attach-enabled", "size", "snapshot-id", "status",
+		"volume-id", "volume-type",
+	)
+)
+
+func filterNames(names ...string) map[string]bool {
+	var result = map[string]bool{"tag-key": true}
+	for _, name := range names {
+		result[name] = true
+	}
+	return result
+}
+
+// problem is one thing wrong with the config.  Path locates it in the
+// file, such as jobs[0].instance_filters[1].key, and Line is filled in
+// from it.
+type problem struct {
+	Line int
+	Path string
+	Msg  string
+}
+
+type problems []problem
+
+func (p *problems) add(path string, format string, args ...interface{}) {
+	*p = append(*p, problem{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// format returns p as file:line: path: msg, leaving out what is unknown.
+func (p problem) format(file string) string {
+	var result = file
+	if p.Line > 0 {
+		result += ":" + strconv.Itoa(p.Line)
+	}
+	if p.Path != "" {
+		result += ": " + p.Path
+	}
+	return result + ": " + p.Msg
+}
+
+// loadConfig reads the config at path strictly: unknown keys and values of
+// the wrong type are problems, as is anything checkConfig finds.  The
+// returned error is only for a file that cannot be read.
+func loadConfig(path string) (config, problems, error) {
+	var c = config{}
+	dump, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, nil, err
+	}
+	var result problems
+	if err := yaml.UnmarshalStrict(dump, &c); err != nil {
+		result = yamlProblems(err)
+	}
+	lines := indexLines(dump)
+	for _, p := range checkConfig(c) {
+		p.Line = lines.find(p.Path)
+		result = append(result, p)
+	}
+	return c, result, nil
+}
+
+// yamlProblems splits a yaml error into one problem per line it reports.
+func yamlProblems(err error) problems {
+	var msgs = []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	}
+	var result problems
+	for _, msg := range msgs {
+		var p = problem{Msg: msg}
+		if m := yamlLinePattern.FindStringSubmatch(msg); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Msg = m[2]
+		}
+		if m := unknownKeyPattern.FindStringSubmatch(p.Msg); m != nil {
+			p.Msg = fmt.Sprintf("unknown key %q", m[1])
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// checkConfig returns every problem with c that can be found without
+// calling AWS.
+func checkConfig(c config) problems {
+	var result problems
+	for _, key := range sortedKeys(c.Settings) {
+		if err := checkSetting(key, c.Settings[key]); err != nil {
+			result.add("settings."+key, "%s", err.Error())
+		}
+	}
+	checkFilters(&result, "filters", c.Filters, backupFilterNames)
+	checkFilters(&result, "instance_filters", c.InstanceFilters, instanceFilterNames)
+	checkFilters(&result, "volume_filters", c.VolumeFilters, volumeFilterNames)
+	if len(c.Jobs) > 0 {
+		for _, key := range c.jobKeys() {
+			result.add(key, "belongs in a job when jobs are configured")
+		}
+	}
+	checkBackup(&result, "", c.Retention, c.Tags, c.ShareAccounts, c.Hooks)
+	for i, target := range c.Copies {
+		checkRegion(&result, fmt.Sprintf("copies[%d].region", i), target.Region)
+	}
+	checkVault(&result, "vault", c.Vault)
+
+	var seen = map[string]int{}
+	for i, j := range c.Jobs {
+		checkJob(&result, fmt.Sprintf("jobs[%d]", i), j)
+		if first, ok := seen[j.Name]; ok && j.Name != "" {
+			result.add(
+				fmt.Sprintf("jobs[%d].name", i),
+				"job %q is already configured as jobs[%d]",
+				j.Name,
+				first,
+			)
+			continue
+		}
+		seen[j.Name] = i
+	}
+	return result
+}
+
+func checkJob(result *problems, path string, j jobConfig) {
+	if err := j.validate(); err != nil {
+		result.add(path, "%s", err.Error())
+	}
+	checkDuration(result, path+".time_to_save", j.TimeToSave)
+	if j.InstanceID != "" && !instanceIDPattern.MatchString(j.InstanceID) {
+		result.add(path+".instance_id", "%q is not an instance ID", j.InstanceID)
+	}
+	if j.VolumeID != "" && !volumeIDPattern.MatchString(j.VolumeID) {
+		result.add(path+".volume_id", "%q is not a volume ID", j.VolumeID)
+	}
+	checkFilters(result, path+".filters", j.Filters, backupFilterNames)
+	checkFilters(result, path+".instance_filters", j.InstanceFilters, instanceFilterNames)
+	checkFilters(result, path+".volume_filters", j.VolumeFilters, volumeFilterNames)
+	for i, region := range j.Regions {
+		checkRegion(result, fmt.Sprintf("%s.regions[%d]", path, i), region)
+	}
+	for i, target := range j.Copies {
+		copyPath := fmt.Sprintf("%s.copies[%d].region", path, i)
+		checkRegion(result, copyPath, target.Region)
+		for _, region := range j.regions() {
+			if target.Region == region {
+				result.add(copyPath, "must differ from the job's region %q", region)
+			}
+		}
+	}
+	checkBackup(result, path, j.Retention, j.Tags, j.ShareAccounts, j.Hooks)
+	checkVault(result, path+".vault", j.Vault)
+	checkRetention(result, path, j)
+
+	// retention is checked above, so only the mode is left to report here
+	var opts = snapshot.Options{
+		ImageName:         "validate",
+		KeepLast:          1,
+		Mode:              j.Mode,
+		NoReboot:          j.NoReboot,
+		ExcludeBootVolume: j.ExcludeBootVolume,
+		ExcludeDevices:    j.ExcludeDevices,
+	}
+	if j.volumes() {
+		opts.Mode = snapshot.ModeVolume
+	}
+	if err := opts.Validate(); err != nil {
+		result.add(path+".mode", "%s", err.Error())
+	}
+	if opts.Mode != "" && opts.Mode != snapshot.ModeImage &&
+		(len(j.Copies) > 0 || len(j.ShareAccounts) > 0 || j.Vault != nil) {
+		result.add(
+			path,
+			"copies, share_accounts and vault are not supported in %s mode",
+			opts.Mode,
+		)
+	}
+}
+
+// checkRetention reports a job, or its vault, whose retention would keep
+// no backups at all.  Durations that cannot be read are already reported.
+func checkRetention(result *problems, path string, j jobConfig) {
+	if j.TimeToSave.err != nil {
+		return
+	}
+	if err := snapshot.ValidateRetention(j.timeToSave(), j.KeepLast, j.Retention); err != nil {
+		result.add(path+".time_to_save", "%s", err.Error())
+	}
+	v := j.Vault
+	if v == nil || v.TimeToSave.err != nil {
+		return
+	}
+	var timeToSave = v.TimeToSave.seconds()
+	if timeToSave == 0 {
+		timeToSave = j.timeToSave()
+	}
+	if err := snapshot.ValidateRetention(timeToSave, v.KeepLast, v.Retention); err != nil {
+		result.add(path+".vault.time_to_save", "%s", err.Error())
+	}
+}
+
+// checkBackup checks the settings jobs share with the top level of the
+// config.
+func checkBackup(
+	result *problems,
+	path string,
+	retention snapshot.Schedule,
+	tags map[string]string,
+	shareAccounts []string,
+	hooks hooksConfig,
+) {
+	var prefix = path
+	if prefix != "" {
+		prefix += "."
+	}
+	if err := retention.Validate(); err != nil {
+		result.add(prefix+"retention", "%s", err.Error())
+	}
+	if err := (snapshot.Options{ImageName: "validate", KeepLast: 1, Tags: tags}).Validate(); err != nil {
+		result.add(prefix+"tags", "%s", err.Error())
+	}
+	if err := snapshot.ValidateAccountIDs(shareAccounts); err != nil {
+		result.add(prefix+"share_accounts", "%s", err.Error())
+	}
+	checkDuration(result, prefix+"hooks.pre.timeout", hooks.Pre.Timeout)
+	checkDuration(result, prefix+"hooks.post.timeout", hooks.Post.Timeout)
+}
+
+func checkDuration(result *problems, path string, d duration) {
+	switch {
+	case d.err != nil:
+		result.add(path, "%s", d.err.Error())
+	case d.Duration < 0:
+		result.add(path, "must not be negative")
+	}
+}
+
+func checkVault(result *problems, path string, v *vaultConfig) {
+	if v == nil {
+		return
+	}
+	if err := v.validate(); err != nil {
+		result.add(path, "%s", err.Error())
+	}
+	checkDuration(result, path+".time_to_save", v.TimeToSave)
+	if v.Region != "" {
+		checkRegion(result, path+".region", v.Region)
+	}
+	checkFilters(result, path+".filters", v.Filters, backupFilterNames)
+}
+
+func checkRegion(result *problems, path string, region string) {
+	if !regionPattern.MatchString(region) {
+		result.add(path, "%q is not an AWS region", region)
+	}
+}
+
+// checkFilters checks that each filter has a name known to the call it is
+// sent to and at least one value, since a filter without values matches
+// everything.
+func checkFilters(
+	result *problems,
+	path string,
+	filters []filterConfig,
+	known map[string]bool,
+) {
+	for i, f := range filters {
+		var filterPath = fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case f.Key == "":
+			result.add(filterPath+".key", "filter name is missing")
+		case strings.HasPrefix(f.Key, "tag:"):
+			if f.Key == "tag:" {
+				result.add(filterPath+".key", "tag filter %q is missing a tag key", f.Key)
+			}
+		case known["network-interface.*"] &&
+			strings.HasPrefix(f.Key, "network-interface."):
+		case !known[f.Key]:
+			result.add(filterPath+".key", "unknown filter name %q", f.Key)
+		}
+		if len(f.Values) == 0 {
+			result.add(filterPath+".values", "filter %q has no values", f.Key)
+		}
+	}
+}
+
+// lineIndex maps paths such as jobs[0].filters[1].key to the line they
+// are on.
+type lineIndex map[string]int
+
+// find returns the line of path, or of its nearest ancestor in the file,
+// or 0 if neither is.
+func (l lineIndex) find(path string) int {
+	for path != "" {
+		if line, ok := l[path]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return 0
+}
+
+// indexLines indexes the block style mappings and sequences in dump.  It
+// only locates problems, so flow style and multi-line scalars are left to
+// the yaml parser and fall back to their parent's line.
+func indexLines(dump []byte) lineIndex {
+	type frame struct {
+		indent int
+		path   string
+		seq    bool
+		item   bool
+		next   int
+	}
+	var (
+		result = lineIndex{}
+		stack  = []*frame{{indent: -1}}
+	)
+	for i, raw := range strings.Split(string(dump), "\n") {
+		var (
+			line    = i + 1
+			content = strings.TrimLeft(raw, " ")
+			col     = len(raw) - len(content)
+		)
+		if content == "" || strings.HasPrefix(content, "#") ||
+			strings.HasPrefix(content, "---") {
+			continue
+		}
+		for content == "-" || strings.HasPrefix(content, "- ") {
+			for top := stack[len(stack)-1]; top.indent > col ||
+				(top.indent == col && top.item); top = stack[len(stack)-1] {
+				stack = stack[:len(stack)-1]
+			}
+			top := stack[len(stack)-1]
+			if !top.seq || top.indent != col {
+				top = &frame{indent: col, path: top.path, seq: true}
+				stack = append(stack, top)
+			}
+			path := fmt.Sprintf("%s[%d]", top.path, top.next)
+			top.next++
+			result[path] = line
+			rest := strings.TrimLeft(content[1:], " ")
+			col += len(content) - len(rest)
+			content = rest
+			stack = append(stack, &frame{indent: col, path: path, item: true})
+		}
+		colon := strings.Index(content, ":")
+		if colon <= 0 || (colon+1 < len(content) && content[colon+1] != ' ') {
+			continue
+		}
+		for top := stack[len(stack)-1]; top.indent > col ||
+			(top.indent == col && !top.item); top = stack[len(stack)-1] {
+			stack = stack[:len(stack)-1]
+		}
+		key := strings.Trim(strings.TrimSpace(content[:colon]), `"'`)
+		path := key
+		if parent := stack[len(stack)-1].path; parent != "" {
+			path = parent + "." + key
+		}
+		result[path] = line
+		stack = append(stack, &frame{indent: col, path: path})
+	}
+	return result
+}
+
+// validateConfig reports every problem with the config at path to w and
+// returns how many there were.
+func validateConfig(w io.Writer, path string) int {
+	_, found, err := loadConfig(path)
+	if err != nil {
+		fmt.Fprintln(w, err.Error())
+		return 1
+	}
+	for _, p := range found {
+		fmt.Fprintln(w, p.format(path))
+	}
+	if len(found) == 0 {
+		fmt.Fprintln(w, path, "is valid")
+	}
+	return len(found)
+}
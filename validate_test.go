@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigSample(t *testing.T) {
+	c, found, err := loadConfig("config.yml.sample")
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	if len(found) > 0 {
+		t.Errorf("Expected the sample to be valid but got %v", found)
+	}
+	if len(c.Jobs) != 3 {
+		t.Errorf("Expected 3 jobs got %d", len(c.Jobs))
+	}
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "config.yml")
+	err = ioutil.WriteFile(path, []byte(`filters:
+    -
+      key: "owner-id"
+      value:
+        - "SomeFakeId1234"
+jobs:
+    -
+      name: "web"
+      instance_filters:
+          -
+            key: "tag-kye"
+            values:
+              - "daily"
+      regions:
+          - "us-east-1"
+          - "useast2"
+    -
+      name: "db"
+      instance_id: "db01"
+      image_name: "db01.data"
+      keep_last: -1
+      retention:
+          daily: 7
+          hourly: 24
+    -
+      name: "cache"
+      volume_tags: "Backup=daily"
+      time_to_save: "7 days"
+settings:
+    keep_last: "many"
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected nil but got %v", err)
+	}
+	var result = []string{}
+	for _, p := range found {
+		result = append(result, strings.TrimPrefix(p.format(path), dir+"/"))
+	}
+	var expect = []string{
+		`config.yml:4: unknown key "value"`,
+		`config.yml:24: unknown key "hourly"`,
+		`config.yml:30: settings.keep_last: "many" is not a valid keep-last`,
+		`config.yml:2: filters[0].values: filter "owner-id" has no values`,
+		`config.yml:1: filters: belongs in a job when jobs are configured`,
+		`config.yml:11: jobs[0].instance_filters[0].key: unknown filter name "tag-kye"`,
+		`config.yml:16: jobs[0].regions[1]: "useast2" is not an AWS region`,
+		`config.yml:17: jobs[1]: time_to_save and keep_last must not be negative`,
+		`config.yml:19: jobs[1].instance_id: "db01" is not an instance ID`,
+		`config.yml:28: jobs[2].time_to_save: "7 days" is not a duration such as 3600, 36h, 7d or 2w`,
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Join(expect, "\n"), strings.Join(result, "\n"))
+	}
+
+	var out bytes.Buffer
+	if n := validateConfig(&out, path); n != len(expect) {
+		t.Errorf("Expected %d problems got %d", len(expect), n)
+	}
+	if n := validateConfig(&out, filepath.Join(dir, "missing.yml")); n == 0 {
+		t.Error("Expected a missing config to be reported")
+	}
+}
+
+func TestCheckFilters(t *testing.T) {
+	var tests = []struct {
+		filter filterConfig
+		known  map[string]bool
+		fail   bool
+	}{
+		{filterConfig{"owner-id", []string{"self"}}, backupFilterNames, false},
+		{filterConfig{"tag:Backup", []string{"daily"}}, backupFilterNames, false},
+		{filterConfig{"tag-key", []string{"Backup"}}, volumeFilterNames, false},
+		{filterConfig{"network-interface.vpc-id", []string{"vpc-1"}}, instanceFilterNames, false},
+		{filterConfig{"name", []string{"web*"}}, backupFilterNames, true},
+		{filterConfig{"network-interface.vpc-id", []string{"vpc-1"}}, volumeFilterNames, true},
+		{filterConfig{"tag:", []string{"daily"}}, backupFilterNames, true},
+		{filterConfig{"", []string{"daily"}}, backupFilterNames, true},
+		{filterConfig{"owner-id", nil}, backupFilterNames, true},
+	}
+	for _, test := range tests {
+		var result problems
+		checkFilters(&result, "filters", []filterConfig{test.filter}, test.known)
+		if test.fail != (len(result) > 0) {
+			t.Errorf("Expected failure %t for %+v but got %v", test.fail, test.filter, result)
+		}
+	}
+}
+
+func TestCheckRetention(t *testing.T) {
+	defer func(d time.Duration) { *timeToSave = d }(*timeToSave)
+	*timeToSave = 0
+
+	var vault = &vaultConfig{RoleARN: "arn:aws:iam::210987654321:role/vault"}
+	var tests = []struct {
+		job    jobConfig
+		expect []string
+	}{
+		{job: jobConfig{KeepLast: 3}},
+		{job: jobConfig{TimeToSave: duration{Duration: time.Hour}, Vault: vault}},
+		{job: jobConfig{}, expect: []string{"jobs[0].time_to_save"}},
+		{job: jobConfig{KeepLast: 3, Vault: vault}, expect: []string{"jobs[0].vault.time_to_save"}},
+		// an unreadable time_to_save is already reported on its own
+		{job: jobConfig{TimeToSave: duration{err: fmt.Errorf("bad")}}},
+	}
+	for _, test := range tests {
+		var result problems
+		checkRetention(&result, "jobs[0]", test.job)
+		var paths []string
+		for _, p := range result {
+			paths = append(paths, p.Path)
+		}
+		if !reflect.DeepEqual(paths, test.expect) {
+			t.Errorf("Expected %v for %+v got %v", test.expect, test.job, result)
+		}
+	}
+}
+
+func TestIndexLines(t *testing.T) {
+	var lines = indexLines([]byte(`# comment
+jobs:
+- name: web
+  copies:
+    - region: us-west-2
+    -
+      region: eu-west-1
+- name: db
+top: 1
+`))
+	var expect = lineIndex{
+		"jobs":                     2,
+		"jobs[0]":                  3,
+		"jobs[0].name":             3,
+		"jobs[0].copies":           4,
+		"jobs[0].copies[0]":        5,
+		"jobs[0].copies[0].region": 5,
+		"jobs[0].copies[1]":        6,
+		"jobs[0].copies[1].region": 7,
+		"jobs[1]":                  8,
+		"jobs[1].name":             8,
+		"top":                      9,
+	}
+	if !reflect.DeepEqual(lines, expect) {
+		t.Errorf("Expected %v got %v", expect, lines)
+	}
+	if line := lines.find("jobs[1].regions[0]"); line != 8 {
+		t.Errorf("Expected a missing path to fall back to its parent's line got %d", line)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// vaultConfig describes a separate backup account that keeps its own copy
+// of every backup.  The tool assumes RoleARN in that account, shares each
+// new image with it and copies the image there, so the vault owns an
+// independent copy that survives a compromise of the source account.
+type vaultConfig struct {
+	RoleARN    string            `yaml:"role_arn"`
+	ExternalID string            `yaml:"external_id,omitempty"`
+	Region     string            `yaml:"region,omitempty"`
+	KmsKeyID   string            `yaml:"kms_key_id,omitempty"`
+	TimeToSave duration          `yaml:"time_to_save,omitempty"`
+	KeepLast   int               `yaml:"keep_last,omitempty"`
+	Retention  snapshot.Schedule `yaml:"retention,omitempty"`
+	Filters    []filterConfig    `yaml:"filters,omitempty"`
+}
+
+// accountID returns the vault account, taken from RoleARN which has the
+// form arn:aws:iam::<account>:role/<name>.
+func (v *vaultConfig) accountID() (string, error) {
+	parts := strings.Split(v.RoleARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" ||
+		!strings.HasPrefix(parts[5], "role/") {
+		return "", fmt.Errorf("Vault role_arn %q is not an IAM role ARN", v.RoleARN)
+	}
+	if err := snapshot.ValidateAccountIDs([]string{parts[4]}); err != nil {
+		return "", err
+	}
+	return parts[4], nil
+}
+
+func (v *vaultConfig) validate() error {
+	if _, err := v.accountID(); err != nil {
+		return err
+	}
+	if v.TimeToSave.Duration < 0 || v.KeepLast < 0 {
+		return fmt.Errorf("Vault time_to_save and keep_last must not be negative")
+	}
+	return v.Retention.Validate()
+}
+
+// target returns the copy target for the vault, using credentials from
+// assuming RoleARN.  Unset region and time_to_save fall back to the source
+// region and time-to-save.
+func (v *vaultConfig) target(
+	sess *session.Session,
+	sourceRegion string,
+	sourceTimeToSave int64,
+) snapshot.CopyTarget {
+	var (
+		region     = v.Region
+		timeToSave = v.TimeToSave.seconds()
+	)
+	if region == "" {
+		region = sourceRegion
+	}
+	if timeToSave == 0 {
+		timeToSave = sourceTimeToSave
+	}
+	account, _ := v.accountID()
+	creds := stscreds.NewCredentials(
+		sess,
+		v.RoleARN,
+		func(p *stscreds.AssumeRoleProvider) {
+			if v.ExternalID != "" {
+				p.ExternalID = aws.String(v.ExternalID)
+			}
+		},
+	)
+	return snapshot.CopyTarget{
+		Client:     newEC2(sess, region, &aws.Config{Credentials: creds}),
+		Region:     region,
+		KmsKeyID:   v.KmsKeyID,
+		Account:    account,
+		TimeToSave: timeToSave,
+		KeepLast:   v.KeepLast,
+		Schedule:   v.Retention,
+		Filters:    toEC2Filters(v.Filters),
+	}
+}
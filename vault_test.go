@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/PermissionData/ec2_snapshot/snapshot"
+)
+
+func TestVaultConfig(t *testing.T) {
+	var tests = []struct {
+		vault   vaultConfig
+		account string
+		fail    bool
+	}{
+		{
+			vault: vaultConfig{
+				RoleARN: "arn:aws:iam::210987654321:role/ec2-snapshot-vault",
+			},
+			account: "210987654321",
+		},
+		{
+			vault: vaultConfig{
+				RoleARN:  "arn:aws:iam::210987654321:role/path/vault",
+				KeepLast: 3,
+			},
+			account: "210987654321",
+		},
+		{
+			vault: vaultConfig{RoleARN: "arn:aws:iam::210987654321:user/bob"},
+			fail:  true,
+		},
+		{
+			vault: vaultConfig{RoleARN: "arn:aws:iam::2109:role/vault"},
+			fail:  true,
+		},
+		{
+			vault: vaultConfig{RoleARN: ""},
+			fail:  true,
+		},
+		{
+			vault: vaultConfig{
+				RoleARN:  "arn:aws:iam::210987654321:role/vault",
+				KeepLast: -1,
+			},
+			fail: true,
+		},
+		{
+			vault: vaultConfig{
+				RoleARN:   "arn:aws:iam::210987654321:role/vault",
+				Retention: snapshot.Schedule{Daily: -1},
+			},
+			fail: true,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.vault.validate()
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected an error for %+v but got nil", test.vault)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected nil but got %v", err)
+		}
+		account, _ := test.vault.accountID()
+		if account != test.account {
+			t.Errorf("Expected %s got %s", test.account, account)
+		}
+	}
+}